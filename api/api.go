@@ -11,18 +11,26 @@ import (
 	"github.com/project0/certjunkie/certstore"
 )
 
-func NewApiServer(listen string, store *certstore.CertStore) {
+// NewApiServer starts the api, csrAllowed limits the names of submitted certificate signing requests.
+// Certificate signing requests are refused if no name is allowed, unless csrAllowAll is set.
+func NewApiServer(listen string, store *certstore.CertStore, csrAllowed []string, csrAllowAll bool) {
 
 	apiCert := apiCert{
-		store: store,
+		store:       store,
+		csrAllowed:  csrAllowed,
+		csrAllowAll: csrAllowAll,
 	}
 
 	r := mux.NewRouter()
+	r.HandleFunc("/cert/csr", apiCert.postCSR).Methods(http.MethodPost)
 	r.HandleFunc("/cert/{domain}", apiCert.getJson).Methods(http.MethodGet)
 	r.HandleFunc("/cert/{domain}/cert", apiCert.getCert).Methods(http.MethodGet)
 	r.HandleFunc("/cert/{domain}/ca", apiCert.getCA).Methods(http.MethodGet)
 	r.HandleFunc("/cert/{domain}/key", apiCert.getKey).Methods(http.MethodGet)
 	r.HandleFunc("/cert/{domain}/bundle", apiCert.getBundle).Methods(http.MethodGet)
+	r.HandleFunc("/cert/{domain}/rotate", apiCert.rotateKey).Methods(http.MethodPost)
+	r.HandleFunc("/cert/{domain}/history", apiCert.getHistory).Methods(http.MethodGet)
+	r.HandleFunc("/cert/{domain}/history/{id}/restore", apiCert.restore).Methods(http.MethodPost)
 
 	log.Info().Str("addr", listen).Msg("Start http server")
 	go func() {
@@ -3,21 +3,29 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/docker/libkv/store"
 	"github.com/gorilla/mux"
 
 	"github.com/project0/certjunkie/certstore"
 )
 
+// maxCSRSize limits the request body of submitted csr
+const maxCSRSize = 64 * 1024
+
 type apiCert struct {
-	store *certstore.CertStore
+	store      *certstore.CertStore
+	csrAllowed []string
+	// csrAllowAll disables the check of the csr names
+	csrAllowAll bool
 }
 
-// certRequest obtains a cert from the certstore
-func (a *apiCert) certRequest(w http.ResponseWriter, r *http.Request) *certstore.CertificateResource {
+// parseRequest reads the cert request from the url
+func (a *apiCert) parseRequest(w http.ResponseWriter, r *http.Request) *certstore.CertRequest {
 	var err error
 	vars := mux.Vars(r)
 	if vars["domain"] == "" {
@@ -25,7 +33,7 @@ func (a *apiCert) certRequest(w http.ResponseWriter, r *http.Request) *certstore
 		return nil
 	}
 	query := r.URL.Query()
-	cr := certstore.CertRequest{
+	cr := &certstore.CertRequest{
 		Domain:    vars["domain"],
 		ValidDays: 30,
 	}
@@ -46,7 +54,31 @@ func (a *apiCert) certRequest(w http.ResponseWriter, r *http.Request) *certstore
 		cr.San = strings.Split(query.Get("san"), ",")
 	}
 
-	cert, err := a.store.GetCertificate(&cr)
+	if query.Get("reusekey") != "" {
+		reuseKey, err := strconv.ParseBool(query.Get("reusekey"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid value for parameter reusekey: %v", err), http.StatusBadRequest)
+			return nil
+		}
+		cr.ReuseKey = &reuseKey
+	}
+
+	if err := cr.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	return cr
+}
+
+// certRequest obtains a cert from the certstore
+func (a *apiCert) certRequest(w http.ResponseWriter, r *http.Request) *certstore.CertificateResource {
+	cr := a.parseRequest(w, r)
+	if cr == nil {
+		return nil
+	}
+
+	cert, err := a.store.GetCertificate(cr)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return nil
@@ -55,6 +87,68 @@ func (a *apiCert) certRequest(w http.ResponseWriter, r *http.Request) *certstore
 	return cert
 }
 
+// rotateKey obtains a new cert with a new private key
+func (a *apiCert) rotateKey(w http.ResponseWriter, r *http.Request) {
+	cr := a.parseRequest(w, r)
+	if cr == nil {
+		return
+	}
+
+	cert, err := a.store.RotateKey(cr)
+	if err == certstore.ErrRotatedRecently {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(cert)
+}
+
+// getHistory lists the archived certs of the domain, the private keys are not part of the response
+func (a *apiCert) getHistory(w http.ResponseWriter, r *http.Request) {
+	cr := a.parseRequest(w, r)
+	if cr == nil {
+		return
+	}
+
+	history, err := a.store.History(cr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(history)
+}
+
+// restore replaces the stored cert with an archived one
+func (a *apiCert) restore(w http.ResponseWriter, r *http.Request) {
+	cr := a.parseRequest(w, r)
+	if cr == nil {
+		return
+	}
+
+	cert, err := a.store.Restore(cr, mux.Vars(r)["id"])
+	if err == store.ErrKeyNotFound {
+		http.Error(w, fmt.Sprintf("Certificate %q not found in history", mux.Vars(r)["id"]), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(cert)
+}
+
 func (a *apiCert) getJson(w http.ResponseWriter, r *http.Request) {
 	cert := a.certRequest(w, r)
 	if cert == nil {
@@ -100,3 +194,49 @@ func (a *apiCert) getBundle(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write(append(cert.GetNoBundleCertificate(), cert.IssuerCertificate...))
 }
+
+// postCSR obtains a cert for the submitted csr, the response contains no private key
+func (a *apiCert) postCSR(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCSRSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot read request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	cr := certstore.CSRRequest{
+		ValidDays: 30,
+	}
+	cr.CSR, err = certstore.ParseCSR(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid csr: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if !a.csrAllowAll {
+		for _, domain := range certstore.CSRDomains(cr.CSR) {
+			if !certstore.DomainAllowed(domain, a.csrAllowed) {
+				http.Error(w, fmt.Sprintf("Domain name %q is not allowed", domain), http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	query := r.URL.Query()
+	if query.Get("valid") != "" {
+		cr.ValidDays, err = strconv.Atoi(query.Get("valid"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid value for parameter valid: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	cert, err := a.store.GetCertificateForCSR(&cr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(cert)
+}
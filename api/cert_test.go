@@ -0,0 +1,33 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostCSRAllowed(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "www.example.com"},
+	}, key)
+	assert.NoError(t, err)
+
+	for _, a := range []*apiCert{
+		{},
+		{csrAllowed: []string{"example.com"}},
+	} {
+		w := httptest.NewRecorder()
+		a.postCSR(w, httptest.NewRequest(http.MethodPost, "/cert/csr", bytes.NewReader(csr)))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+}
@@ -1,7 +1,9 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,55 +11,174 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
 
 	"github.com/project0/certjunkie/certstore"
 )
 
+// ErrUnavailable is returned if none of the api servers could serve the request after all retries
+var ErrUnavailable = errors.New("certjunkie api is not available")
+
 // Client talks with the API
 type Client struct {
 	Address string
+	// Failover lists additional api addresses which are tried in order if a request to Address fails
+	Failover []string
+	// Timeout of a single request, issuing a new certificate may take some minutes
+	Timeout time.Duration
+	// Retries is the number of retry rounds over all addresses after the first one failed
+	Retries int
+	// Backoff is the wait time before the first retry round, it is doubled every round up to MaxBackoff.
+	// A longer Retry-After of the server is always honored.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// retryableError is a failure which may be solved by trying again or with another server
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
 }
 
 // Get retrieves the cert, private key and ca bundle
 func (c *Client) Get(domain string, san []string, onlyCN bool, valid int) (cert *certstore.CertificateResource, err error) {
+	return c.GetRequest(&certstore.CertRequest{
+		Domain:     domain,
+		DomainIsCn: onlyCN,
+		ValidDays:  valid,
+		San:        san,
+	})
+}
 
-	var (
-		resp *http.Response
-		u    *url.URL
-	)
-	client := http.DefaultClient
-
-	u, err = url.Parse(c.Address + "/cert/" + domain)
-	if err != nil {
-		return
-	}
+// GetRequest retrieves the cert, private key and ca bundle for all parameters of the request
+func (c *Client) GetRequest(request *certstore.CertRequest) (cert *certstore.CertificateResource, err error) {
 
 	// Add queries
-	q := u.Query()
-	if onlyCN {
+	q := url.Values{}
+	if request.DomainIsCn {
 		q.Set("onlycn", "1")
 	}
+	if request.ValidDays != 0 {
+		q.Set("valid", strconv.Itoa(request.ValidDays))
+	}
+	if len(request.San) > 0 {
+		q.Set("san", strings.Join(request.San, ","))
+	}
+	if request.ReuseKey != nil {
+		q.Set("reusekey", strconv.FormatBool(*request.ReuseKey))
+	}
+
+	return c.request(http.MethodGet, "/cert/"+request.Domain, q, nil)
+}
+
+// GetForCSR submits the pem encoded csr and retrieves the cert and ca bundle, the private key stays local
+func (c *Client) GetForCSR(csr []byte, valid int) (cert *certstore.CertificateResource, err error) {
+	q := url.Values{}
 	if valid != 0 {
 		q.Set("valid", strconv.Itoa(valid))
 	}
-	if len(san) > 0 {
-		q.Set("san", strings.Join(san, ","))
+
+	return c.request(http.MethodPost, "/cert/csr", q, csr)
+}
+
+// request sends the request to the api with retries and failover
+func (c *Client) request(method string, path string, q url.Values, body []byte) (cert *certstore.CertificateResource, err error) {
+	client := &http.Client{Timeout: c.Timeout}
+	backoff := c.Backoff
+	for round := 0; ; round++ {
+		var wait time.Duration
+		for _, address := range c.addresses() {
+			cert, err = c.do(client, method, address+path, q, body)
+			if err == nil {
+				return cert, nil
+			}
+
+			var retryErr *retryableError
+			if !errors.As(err, &retryErr) {
+				return nil, err
+			}
+			log.Warn().Err(err).Str("address", address).Msg("certjunkie api request failed")
+			if retryErr.retryAfter > wait {
+				wait = retryErr.retryAfter
+			}
+		}
+
+		if round >= c.Retries {
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+
+		// the server may tell us how long to wait, MaxBackoff only limits our own backoff
+		if c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+		if backoff > wait {
+			wait = backoff
+		}
+		log.Info().Dur("wait", wait).Int("retry", round+1).Msg("retry certificate request")
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// do sends a single request to the api
+func (c *Client) do(client *http.Client, method string, rawURL string, q url.Values, body []byte) (*certstore.CertificateResource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
 	}
 	u.RawQuery = q.Encode()
 
-	resp, err = client.Get(u.String())
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
 	if err != nil {
-		return
+		return nil, err
 	}
 
+	resp, err := client.Do(req)
+	if err != nil {
+		// connection failures and timeouts
+		return nil, &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to retrieve cert: %s", string(respBody))
+		err := fmt.Errorf("failed to retrieve cert: %s", string(respBody))
+		if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+			return nil, &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		}
+		return nil, err
 	}
 
-	cert = &certstore.CertificateResource{}
-	err = json.NewDecoder(resp.Body).Decode(cert)
-	return
+	cert := &certstore.CertificateResource{}
+	return cert, json.NewDecoder(resp.Body).Decode(cert)
+}
+
+func (c *Client) addresses() []string {
+	return append([]string{c.Address}, c.Failover...)
+}
+
+// parseRetryAfter supports both formats of the header, delay in seconds and http date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
 }
 
 // WriteCert writes the cert to file
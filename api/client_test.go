@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+func TestClientFailover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "acme failed", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/cert/example.com", r.URL.Path)
+		assert.Equal(t, "www.example.com", r.URL.Query().Get("san"))
+		json.NewEncoder(w).Encode(&certstore.CertificateResource{Domain: "example.com"})
+	}))
+	defer up.Close()
+
+	c := &Client{Address: down.URL, Failover: []string{up.URL}}
+	cert, err := c.Get("example.com", []string{"www.example.com"}, false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", cert.Domain)
+}
+
+func TestClientRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(&certstore.CertificateResource{Domain: "example.com"})
+	}))
+	defer server.Close()
+
+	c := &Client{Address: server.URL, Retries: 1, Backoff: time.Millisecond}
+	_, err := c.Get("example.com", nil, false, 0)
+	assert.True(t, errors.Is(err, ErrUnavailable))
+
+	c.Retries = 2
+	cert, err := c.Get("example.com", nil, false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", cert.Domain)
+	assert.Equal(t, 3, requests)
+}
+
+func TestClientRetryAfterExceedsMaxBackoff(t *testing.T) {
+	requests := 0
+	var first time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "busy", http.StatusTooManyRequests)
+			return
+		}
+		assert.True(t, time.Since(first) >= time.Second, "retried before Retry-After")
+		json.NewEncoder(w).Encode(&certstore.CertificateResource{Domain: "example.com"})
+	}))
+	defer server.Close()
+
+	c := &Client{Address: server.URL, Retries: 1, Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+	_, err := c.Get("example.com", nil, false, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestClientNoRetryOnClientError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "invalid", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	c := &Client{Address: server.URL, Retries: 3, Backoff: time.Millisecond}
+	_, err := c.Get("example.com", nil, false, 0)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrUnavailable))
+	assert.Equal(t, 1, requests)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+
+	wait := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.True(t, wait > 59*time.Minute && wait <= time.Hour)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+// backupFlags are the flags of the export and import-backup commands
+func backupFlags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "file",
+			Usage:    "Backup file, - for stdout or stdin",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "passphrase",
+			Usage:   "Passphrase to encrypt the backup",
+			EnvVars: flagSetHelperEnvKey("BACKUP_PASSPHRASE"),
+		},
+		&cli.StringFlag{
+			Name:    "passphrase-file",
+			Usage:   "Read the passphrase from file",
+			EnvVars: flagSetHelperEnvKey("BACKUP_PASSPHRASE_FILE"),
+		},
+	}, storageFlags()...)
+}
+
+// backupPassphrase reads the passphrase from the flags
+func backupPassphrase(c *cli.Context) ([]byte, error) {
+	if c.String("passphrase") != "" && c.String("passphrase-file") != "" {
+		return nil, errors.New("passphrase and passphrase-file cannot be used together")
+	}
+	if c.String("passphrase-file") != "" {
+		data, err := os.ReadFile(c.String("passphrase-file"))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(data, "\r\n"), nil
+	}
+	if c.String("passphrase") == "" {
+		return nil, errors.New("passphrase is not set")
+	}
+	return []byte(c.String("passphrase")), nil
+}
+
+// exportCommand writes an encrypted backup of the storage
+func exportCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "export",
+		Usage:       "write an encrypted backup of the account and all certificates",
+		Description: "the backup is a gzipped tar archive encrypted with AES-GCM, the key is derived from the passphrase with scrypt",
+		Flags:       backupFlags(),
+		Action: func(c *cli.Context) error {
+			passphrase, err := backupPassphrase(c)
+			if err != nil {
+				return err
+			}
+			storage, err := newStorage(c)
+			if err != nil {
+				return err
+			}
+			defer storage.Close()
+
+			if c.String("file") == "-" {
+				_, err = certstore.Export(storage, os.Stdout, passphrase)
+				return err
+			}
+			file, err := os.OpenFile(c.String("file"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return err
+			}
+			count, err := certstore.Export(storage, file, passphrase)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(c.String("file"))
+				return err
+			}
+			log.Info().Int("records", count).Str("file", c.String("file")).Msg("storage exported")
+			return nil
+		},
+	}
+}
+
+// importBackupCommand writes the records of a backup to the storage
+func importBackupCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "import-backup",
+		Usage:       "restore the account and certificates of a backup written by export",
+		Description: "the backup is verified before anything is written, existing records are kept unless overwrite is set",
+		Flags: append(backupFlags(), &cli.BoolFlag{
+			Name:  "overwrite",
+			Usage: "Replace existing records with the ones of the backup",
+		}),
+		Action: func(c *cli.Context) error {
+			passphrase, err := backupPassphrase(c)
+			if err != nil {
+				return err
+			}
+			storage, err := newStorage(c)
+			if err != nil {
+				return err
+			}
+			defer storage.Close()
+
+			var r io.Reader = os.Stdin
+			if c.String("file") != "-" {
+				file, err := os.Open(c.String("file"))
+				if err != nil {
+					return err
+				}
+				defer file.Close()
+				r = file
+			}
+
+			result, err := certstore.RestoreBackup(storage, r, passphrase, c.Bool("overwrite"))
+			if result != nil {
+				log.Info().Int("restored", result.Restored).Int("skipped", result.Skipped).Msg("storage restored")
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,299 @@
+package certstore
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/scrypt"
+)
+
+// backupMagic identifies the format of the backup, it is authenticated with every encrypted chunk
+var backupMagic = []byte("certjunkie-backup-v2\n")
+
+const (
+	backupSaltSize = 16
+	// backupChunkSize is the size of the encrypted chunks, the archive is streamed through the encryption
+	backupChunkSize = 64 * 1024
+	// maxBackupSize limits the memory used to restore a backup
+	maxBackupSize = 1 << 30
+)
+
+// errBackupDecrypt hides the reason of a failed decryption
+var errBackupDecrypt = errors.New("cannot decrypt backup, wrong passphrase or modified file")
+
+// errBackupTooLarge is returned if the backup exceeds maxBackupSize
+var errBackupTooLarge = fmt.Errorf("backup is larger than %d bytes", maxBackupSize)
+
+// BackupResult counts the records of a restored backup
+type BackupResult struct {
+	Restored int
+	// Skipped records already exist with the same content or are not overwritten
+	Skipped int
+}
+
+// backupKey derives the encryption key from the passphrase
+func backupKey(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("backup passphrase is empty")
+	}
+	key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce is the counter of the chunk followed by a flag for the last chunk, truncated backups cannot be decrypted.
+// The key is derived with a new salt for every backup, the nonces are never reused for a key.
+func chunkNonce(aead cipher.AEAD, counter uint64, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// chunkWriter encrypts the written data in chunks
+type chunkWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+}
+
+func (c *chunkWriter) seal(chunk []byte, last bool) error {
+	_, err := c.w.Write(c.aead.Seal(nil, chunkNonce(c.aead, c.counter, last), chunk, backupMagic))
+	c.counter++
+	return err
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	// the last chunk is written by Close, even if it is empty
+	for len(c.buf) > backupChunkSize {
+		if err := c.seal(c.buf[:backupChunkSize], false); err != nil {
+			return 0, err
+		}
+		c.buf = c.buf[backupChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (c *chunkWriter) Close() error {
+	return c.seal(c.buf, true)
+}
+
+// sizeLimitReader fails with errBackupTooLarge if more than n bytes are read, instead of silently truncating like io.LimitReader
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		n = int(l.n)
+		l.n = 0
+		return n, errBackupTooLarge
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// chunkReader decrypts the chunks written by chunkWriter
+type chunkReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		chunk := make([]byte, backupChunkSize+c.aead.Overhead())
+		n, err := io.ReadFull(c.r, chunk)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				return 0, errors.New("backup is truncated")
+			}
+			return 0, err
+		}
+		if err == nil {
+			// a full chunk is the last one if nothing follows
+			if _, err = c.r.Peek(1); err != nil && err != io.EOF {
+				return 0, err
+			}
+		}
+		c.done = err != nil
+		c.plain, err = c.aead.Open(nil, chunkNonce(c.aead, c.counter, c.done), chunk[:n], backupMagic)
+		if err != nil {
+			return 0, errBackupDecrypt
+		}
+		c.counter++
+	}
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+// Export writes the account and all certificate records as gzipped tar archive, encrypted with a key derived from the passphrase.
+// The archive is encrypted in chunks while it is written.
+func Export(storage store.Store, w io.Writer, passphrase []byte) (int, error) {
+	keys, err := storedKeys(storage)
+	if err != nil {
+		return 0, err
+	}
+
+	salt := make([]byte, backupSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return 0, err
+	}
+	aead, err := backupKey(passphrase, salt)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(append(append([]byte{}, backupMagic...), salt...)); err != nil {
+		return 0, err
+	}
+
+	encrypted := &chunkWriter{w: w, aead: aead}
+	gz := gzip.NewWriter(encrypted)
+	tw := tar.NewWriter(gz)
+	count := 0
+	for _, key := range keys {
+		pair, err := storage.Get(key)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("cannot read %s: %v", key, err)
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:    indexKey(key),
+			Mode:    0600,
+			Size:    int64(len(pair.Value)),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tw.Write(pair.Value); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	if err := encrypted.Close(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// readBackup decrypts the backup and returns the records by key
+func readBackup(r io.Reader, passphrase []byte) (map[string][]byte, []string, error) {
+	in := bufio.NewReader(&sizeLimitReader{r: r, n: maxBackupSize})
+	header := make([]byte, len(backupMagic)+backupSaltSize)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, nil, errors.New("not a certjunkie backup")
+	}
+	if !bytes.HasPrefix(header, backupMagic) {
+		return nil, nil, errors.New("not a certjunkie backup")
+	}
+	aead, err := backupKey(passphrase, header[len(backupMagic):])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gz, err := gzip.NewReader(&chunkReader{r: in, aead: aead})
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := tar.NewReader(gz)
+	records := map[string][]byte{}
+	keys := []string{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isStoredKey(header.Name) {
+			return nil, nil, fmt.Errorf("unexpected record %s in backup", header.Name)
+		}
+		if err := verifyRecord(header.Name, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid record %s: %v", header.Name, err)
+		}
+		if _, ok := records[header.Name]; !ok {
+			keys = append(keys, header.Name)
+		}
+		records[header.Name] = value
+	}
+	// the last chunk is verified after the end of the archive
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return nil, nil, err
+	}
+	return records, keys, nil
+}
+
+// RestoreBackup writes the records of the backup to the storage.
+// The whole backup is decrypted and verified before anything is written.
+// Existing records with a different content are only replaced with overwrite.
+func RestoreBackup(storage store.Store, r io.Reader, passphrase []byte, overwrite bool) (*BackupResult, error) {
+	records, keys, err := readBackup(r, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BackupResult{}
+	for _, key := range keys {
+		existing, err := storage.Get(key)
+		if err != nil && err != store.ErrKeyNotFound {
+			return result, fmt.Errorf("cannot read %s: %v", key, err)
+		}
+		if existing != nil && (!overwrite || sameRecord(existing.Value, records[key])) {
+			log.Debug().Str("key", key).Msg("skip existing record")
+			result.Skipped++
+			continue
+		}
+		if err := storage.Put(key, records[key], nil); err != nil {
+			return result, fmt.Errorf("cannot write %s: %v", key, err)
+		}
+		result.Restored++
+	}
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package certstore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore/libkv/local"
+)
+
+func TestBackup(t *testing.T) {
+	from, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	to, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+
+	user, _ := json.Marshal(&User{Email: "test@example.com", Key: []byte("account key")})
+	assert.NoError(t, from.Put(pathUser, user, nil))
+	putCertificate(t, from, "certs/example.com.json", testCertificate(t, "example.com"))
+	putCertificate(t, from, "history/certs/example.com/1.json", testCertificate(t, "example.com"))
+
+	backup := &bytes.Buffer{}
+	count, err := Export(from, backup, []byte("secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.NotContains(t, backup.String(), "account key")
+
+	_, err = RestoreBackup(to, bytes.NewReader(backup.Bytes()), []byte("wrong"), false)
+	assert.ErrorContains(t, err, "wrong passphrase")
+	modified := append([]byte{}, backup.Bytes()...)
+	modified[len(modified)-1] ^= 0xff
+	_, err = RestoreBackup(to, bytes.NewReader(modified), []byte("secret"), false)
+	assert.Error(t, err)
+	_, err = to.Get(pathUser)
+	assert.Equal(t, store.ErrKeyNotFound, err)
+
+	// existing records are kept without overwrite
+	assert.NoError(t, to.Put(pathUser, []byte(`{"email":"other@example.com","key":"b3RoZXI="}`), nil))
+	result, err := RestoreBackup(to, bytes.NewReader(backup.Bytes()), []byte("secret"), false)
+	assert.NoError(t, err)
+	assert.Equal(t, &BackupResult{Restored: 2, Skipped: 1}, result)
+	pair, _ := to.Get("certs/example.com.json")
+	original, _ := from.Get("certs/example.com.json")
+	assert.Equal(t, original.Value, pair.Value)
+
+	result, err = RestoreBackup(to, bytes.NewReader(backup.Bytes()), []byte("secret"), true)
+	assert.NoError(t, err)
+	assert.Equal(t, &BackupResult{Restored: 1, Skipped: 2}, result)
+	pair, _ = to.Get(pathUser)
+	assert.Equal(t, user, pair.Value)
+}
+
+func TestBackupChunks(t *testing.T) {
+	aead, err := backupKey([]byte("secret"), make([]byte, backupSaltSize))
+	assert.NoError(t, err)
+	for _, size := range []int{0, backupChunkSize, 3*backupChunkSize + 7} {
+		data := make([]byte, size)
+		rand.Read(data)
+		encrypted := &bytes.Buffer{}
+		w := &chunkWriter{w: encrypted, aead: aead}
+		w.Write(data)
+		assert.NoError(t, w.Close())
+
+		decrypted, err := io.ReadAll(&chunkReader{r: bufio.NewReader(bytes.NewReader(encrypted.Bytes())), aead: aead})
+		assert.NoError(t, err)
+		assert.Equal(t, data, decrypted)
+
+		// the size limit is exceeded
+		limited := &sizeLimitReader{r: bytes.NewReader(encrypted.Bytes()), n: int64(encrypted.Len() - 1)}
+		_, err = io.ReadAll(&chunkReader{r: bufio.NewReader(limited), aead: aead})
+		assert.Equal(t, errBackupTooLarge, err)
+		limited = &sizeLimitReader{r: bytes.NewReader(encrypted.Bytes()), n: int64(encrypted.Len())}
+		decrypted, err = io.ReadAll(&chunkReader{r: bufio.NewReader(limited), aead: aead})
+		assert.NoError(t, err)
+		assert.Equal(t, data, decrypted)
+
+		// the last chunk is missing
+		if size > backupChunkSize {
+			truncated := encrypted.Bytes()[:backupChunkSize+aead.Overhead()]
+			_, err = io.ReadAll(&chunkReader{r: bufio.NewReader(bytes.NewReader(truncated)), aead: aead})
+			assert.Error(t, err)
+		}
+	}
+}
+
+func TestIsStoredKey(t *testing.T) {
+	assert.True(t, isStoredKey("user.json"))
+	assert.True(t, isStoredKey("certs/*.example.com.json"))
+	assert.True(t, isStoredKey("history/csr/example.com/1a.json"))
+	assert.False(t, isStoredKey("certs/../../etc/passwd.json"))
+	assert.False(t, isStoredKey("/user.json"))
+	assert.False(t, isStoredKey("other/example.com.json"))
+}
@@ -2,19 +2,84 @@ package certstore
 
 import (
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
 )
 
+// RecordVersion is the current format of the stored certificate records
+const RecordVersion = 1
+
 // CertificateResource represent everything from our cert
 type CertificateResource struct {
+	// Version of the record format, records without version are upgraded on read
+	Version           int    `json:"version,omitempty"`
 	Domain            string `json:"domain"`
 	PrivateKey        []byte `json:"key"`
 	Certificate       []byte `json:"certificate"`
 	IssuerCertificate []byte `json:"issuer"`
+	// Metadata about the issuance of the cert
+	Metadata *CertificateMetadata `json:"metadata,omitempty"`
+}
+
+// CertificateMetadata describes how and where the cert has been issued
+type CertificateMetadata struct {
+	// IssuedAt is the time the cert has been obtained or imported
+	IssuedAt time.Time `json:"issued"`
+	// CertURL of the cert at the acme server, required for revocation and renewal information
+	CertURL       string `json:"certurl,omitempty"`
+	CertStableURL string `json:"certstableurl,omitempty"`
+	// Account is the registration URI of the acme account
+	Account string `json:"account,omitempty"`
+	// CA is the acme directory the cert has been issued by
+	CA string `json:"ca,omitempty"`
+	// Request are the parameters the cert has been requested with
+	Request *CertRequest `json:"request,omitempty"`
+	// Imported certs have not been issued by certjunkie
+	Imported bool `json:"imported,omitempty"`
+	// LastAccessed is the last time the cert has been requested, it is updated at most once per day
+	LastAccessed time.Time `json:"lastaccessed"`
+}
+
+// decodeRecord reads a stored certificate record and upgrades it to the current version
+func decodeRecord(value []byte) (*CertificateResource, error) {
+	cert := new(CertificateResource)
+	if err := json.Unmarshal(value, cert); err != nil {
+		return nil, err
+	}
+	if cert.Version > RecordVersion {
+		return nil, fmt.Errorf("record version %d is newer than the supported version %d", cert.Version, RecordVersion)
+	}
+	if cert.Version == 0 {
+		cert.upgradeV1()
+	}
+	return cert, nil
+}
+
+// upgradeV1 adds the metadata which can be derived from the certificate to records written without version
+func (c *CertificateResource) upgradeV1() {
+	c.Version = 1
+	if c.Metadata != nil {
+		return
+	}
+	c.Metadata = &CertificateMetadata{}
+	info, err := c.ParseCertificate()
+	if err != nil {
+		return
+	}
+	c.Metadata.IssuedAt = info.NotBefore
+	domains := removeDuplicates(append([]string{c.Domain}, info.DNSNames...))
+	c.Metadata.Request = &CertRequest{Domain: domains[0], San: domains[1:]}
 }
 
-func (c *CertificateResource) parseCert() (*x509.Certificate, error) {
+// ParseCertificate parses the (first) certificate
+func (c *CertificateResource) ParseCertificate() (*x509.Certificate, error) {
 	block, _ := pem.Decode(c.Certificate)
+	if block == nil {
+		return nil, errors.New("no pem encoded certificate found")
+	}
 	return x509.ParseCertificate(block.Bytes)
 }
 
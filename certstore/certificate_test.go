@@ -0,0 +1,35 @@
+package certstore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeRecord(t *testing.T) {
+	legacy := testCertificate(t, "example.com", "www.example.com")
+	value, _ := json.Marshal(legacy)
+	assert.NotContains(t, string(value), `"version"`)
+
+	cert, err := decodeRecord(value)
+	assert.NoError(t, err)
+	assert.Equal(t, RecordVersion, cert.Version)
+	if assert.NotNil(t, cert.Metadata) {
+		info, _ := legacy.ParseCertificate()
+		assert.Equal(t, info.NotBefore, cert.Metadata.IssuedAt)
+		assert.Equal(t, &CertRequest{Domain: "example.com", San: []string{"www.example.com"}}, cert.Metadata.Request)
+		assert.Empty(t, cert.Metadata.CertURL)
+	}
+
+	current := testCertificate(t, "example.org")
+	current.Version = RecordVersion
+	current.Metadata = &CertificateMetadata{CertURL: "https://acme.example/cert/1", CA: "https://acme.example/directory"}
+	value, _ = json.Marshal(current)
+	cert, err = decodeRecord(value)
+	assert.NoError(t, err)
+	assert.Equal(t, current, cert)
+
+	_, err = decodeRecord([]byte(`{"version":99,"domain":"example.com"}`))
+	assert.Error(t, err)
+}
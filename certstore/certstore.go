@@ -6,8 +6,11 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
@@ -19,6 +22,9 @@ import (
 	"github.com/go-acme/lego/v4/registration"
 )
 
+// pathUser is the storage key of the acme account
+const pathUser = "user.json"
+
 type User struct {
 	Email        string                 `json:"email"`
 	Registration *registration.Resource `json:"registration"`
@@ -41,22 +47,58 @@ func (u User) GetPrivateKey() crypto.PrivateKey {
 	return key
 }
 
+// certifier obtains certificates from acme, it is implemented by the certificate.Certifier of lego
+type certifier interface {
+	Obtain(request certificate.ObtainRequest) (*certificate.Resource, error)
+	ObtainForCSR(request certificate.ObtainForCSRRequest) (*certificate.Resource, error)
+}
+
+// ErrRotatedRecently is returned if the key of a certificate is rotated again within the rotateInterval
+var ErrRotatedRecently = errors.New("certificate has been issued recently, retry later")
+
+// rotateInterval is the minimal age of the stored certificate to rotate its key, this protects the acme rate limits
+const rotateInterval = time.Hour
+
 type CertStore struct {
+	// ReuseKey is the default for requests without explicit key reuse setting,
+	// renewals use the private key of the stored certificate instead of generating a new one.
+	ReuseKey bool
+	// SelectionPolicy decides which stored certificate is used if multiple match a request,
+	// DefaultSelectionPolicy is used if not set.
+	SelectionPolicy []SelectionCriterion
+	// HistoryRetention is the number of replaced certificates archived per domain, 0 disables the history
+	HistoryRetention int
+
 	user           *User
 	email          string
 	preferredChain string
+	caDirectory    string
 	client         *lego.Client
+	certifier      certifier
 	sync           *sync.Mutex
 	storage        store.Store
+	index          *index
+	// watched is set while the index is updated by a watch of the storage
+	watched atomic.Bool
+	// indexLoaded is the last reload of the index, it is guarded by sync
+	indexLoaded time.Time
+	// accessed are the requested certificates by key, they are written by flushAccess
+	accessed   map[string]time.Time
+	accessLock sync.Mutex
 }
 
+// indexReloadInterval limits the reloads of the index on misses for storages without watch support
+const indexReloadInterval = 30 * time.Second
+
 func NewCertStore(acmeDirectory string, email string, challengeProvider challenge.Provider, storage store.Store, preferredChain string) (*CertStore, error) {
 	var err error
 	cs := &CertStore{
 		sync:           &sync.Mutex{},
+		index:          newIndex(),
 		email:          email,
 		storage:        storage,
 		preferredChain: preferredChain,
+		caDirectory:    acmeDirectory,
 	}
 
 	// ensure we have a user
@@ -69,6 +111,12 @@ func NewCertStore(acmeDirectory string, email string, challengeProvider challeng
 	if err != nil {
 		return nil, err
 	}
+	if err := cs.index.load(storage); err != nil {
+		return nil, fmt.Errorf("cannot load stored certificates: %v", err)
+	}
+	cs.indexLoaded = time.Now()
+	cs.watchIndex()
+	go cs.flushAccessLoop()
 
 	config := lego.NewConfig(cs.user)
 	config.CADirURL = acmeDirectory
@@ -78,6 +126,7 @@ func NewCertStore(acmeDirectory string, email string, challengeProvider challeng
 	if err != nil {
 		return nil, err
 	}
+	cs.certifier = cs.client.Certificate
 	// we support only dns challenges
 	// set our own dns provider
 	return cs, cs.client.Challenge.SetDNS01Provider(challengeProvider)
@@ -85,7 +134,7 @@ func NewCertStore(acmeDirectory string, email string, challengeProvider challeng
 
 func (c *CertStore) GetUser() (*User, error) {
 
-	fileUser, err := c.storage.Get("user.json")
+	fileUser, err := c.storage.Get(pathUser)
 	if err != nil {
 		//seems not to exist, create new
 		const rsaKeySize = 4096
@@ -110,11 +159,15 @@ func (c *CertStore) SaveUser(user registration.User) error {
 	if err != nil {
 		return err
 	}
-	return c.storage.Put("user.json", jsonContent, nil)
+	return c.storage.Put(pathUser, jsonContent, nil)
 }
 
 // GetCertificate retrieves an certificate from acme or storage
 func (c *CertStore) GetCertificate(request *CertRequest) (*CertificateResource, error) {
+	if err := request.Normalize(); err != nil {
+		return nil, err
+	}
+
 	// Block this request until we got a cert.
 	// This may not perfect but prevents simple concurrent requests to acme servers.
 	// Note: If we want to have concurrency lock between different processes/instances this need to be done by storage level.
@@ -143,23 +196,40 @@ func (c *CertStore) GetCertificate(request *CertRequest) (*CertificateResource,
 	}
 
 	// continue with creating a new one
+	return c.obtain(request, request.reuseKey(c.ReuseKey))
+}
 
-	// check user first....
-	if c.user.Registration == nil {
-		log.Info().Msg("register new user")
-		reg, err := c.client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
-		if err != nil {
-			log.Err(err).Msg("registration failed")
-			return nil, err
-		}
-		// save this
-		c.user.Registration = reg
-		if err := c.SaveUser(c.user); err != nil {
-			log.Err(err).Msg("could not save user registration")
-			return nil, err
+// RotateKey obtains a new certificate with a new private key, even if the stored one is still valid.
+// ErrRotatedRecently is returned if the stored certificate is younger than the rotateInterval.
+func (c *CertStore) RotateKey(request *CertRequest) (*CertificateResource, error) {
+	if err := request.Normalize(); err != nil {
+		return nil, err
+	}
+	c.sync.Lock()
+	defer c.sync.Unlock()
+
+	pair, err := c.storage.Get(request.pathCert())
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	if pair != nil {
+		stored, err := decodeRecord(pair.Value)
+		if err == nil && stored.Metadata != nil && time.Since(stored.Metadata.IssuedAt) < rotateInterval {
+			return nil, ErrRotatedRecently
 		}
 	}
 
+	log.Info().Str("domain", request.Domain).Msg("rotate private key")
+	return c.obtain(request, false)
+}
+
+// obtain requests a new certificate from acme and stores it
+func (c *CertStore) obtain(request *CertRequest, reuseKey bool) (*CertificateResource, error) {
+	// check user first....
+	if err := c.register(); err != nil {
+		return nil, err
+	}
+
 	req := certificate.ObtainRequest{
 		Domains:        request.domains(),
 		Bundle:         false,
@@ -167,17 +237,30 @@ func (c *CertStore) GetCertificate(request *CertRequest) (*CertificateResource,
 		MustStaple:     false,
 		PreferredChain: c.preferredChain,
 	}
-	acmeCerts, err := c.client.Certificate.Obtain(req)
+	if reuseKey {
+		key, err := c.storedPrivateKey(request)
+		if err != nil {
+			log.Warn().Err(err).Str("domain", request.Domain).Msg("cannot reuse private key, generate a new one")
+		}
+		req.PrivateKey = key
+	}
+	acmeCerts, err := c.certifier.Obtain(req)
 	if err != nil {
 		return nil, fmt.Errorf("unable to obtain new certificate: %v", err)
 	}
 
 	// create our own cert resource
-	cert = &CertificateResource{
+	cert := &CertificateResource{
+		Version:           RecordVersion,
 		Domain:            acmeCerts.Domain,
 		PrivateKey:        acmeCerts.PrivateKey,
 		Certificate:       acmeCerts.Certificate,
 		IssuerCertificate: acmeCerts.IssuerCertificate,
+		Metadata:          c.metadata(acmeCerts, request),
+	}
+
+	if err := archive(c.storage, request.pathCert(), c.HistoryRetention); err != nil {
+		log.Warn().Err(err).Str("domain", request.Domain).Msg("cannot archive replaced certificate")
 	}
 
 	// save
@@ -185,50 +268,158 @@ func (c *CertStore) GetCertificate(request *CertRequest) (*CertificateResource,
 	err = c.storage.Put(request.pathCert(), val, nil)
 	if err != nil {
 		log.Err(err).Str("domain", request.Domain).Msg("cannot save certificate in storage")
+	} else if err := c.index.put(request.pathCert(), val); err != nil {
+		log.Err(err).Str("domain", request.Domain).Msg("cannot index certificate")
 	}
 
 	return cert, nil
 }
 
+// History returns the archived certificates of the requested domain, newest first
+func (c *CertStore) History(request *CertRequest) ([]*HistoryEntry, error) {
+	if err := request.Normalize(); err != nil {
+		return nil, err
+	}
+	return History(c.storage, request.pathCert())
+}
+
+// Restore replaces the stored certificate of the requested domain with an archived one
+func (c *CertStore) Restore(request *CertRequest, id string) (*CertificateResource, error) {
+	if err := request.Normalize(); err != nil {
+		return nil, err
+	}
+	c.sync.Lock()
+	defer c.sync.Unlock()
+
+	cert, err := Restore(c.storage, request.pathCert(), id, c.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+	val, _ := json.Marshal(cert)
+	if err := c.index.put(request.pathCert(), val); err != nil {
+		log.Err(err).Str("domain", request.Domain).Msg("cannot index certificate")
+	}
+	return cert, nil
+}
+
+// metadata describes the issuance of the acme certificate
+func (c *CertStore) metadata(acmeCerts *certificate.Resource, request *CertRequest) *CertificateMetadata {
+	metadata := &CertificateMetadata{
+		IssuedAt:      time.Now().UTC(),
+		CertURL:       acmeCerts.CertURL,
+		CertStableURL: acmeCerts.CertStableURL,
+		CA:            c.caDirectory,
+		Request:       request,
+	}
+	metadata.LastAccessed = metadata.IssuedAt
+	if c.user.Registration != nil {
+		metadata.Account = c.user.Registration.URI
+	}
+	return metadata
+}
+
+// storedPrivateKey returns the private key of the stored certificate regardless of its validity
+func (c *CertStore) storedPrivateKey(request *CertRequest) (crypto.PrivateKey, error) {
+	pair, err := c.storage.Get(request.pathCert())
+	if err == store.ErrKeyNotFound {
+		// first certificate for this domain
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := decodeRecord(pair.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(cert.PrivateKey) == 0 {
+		return nil, nil
+	}
+	return certcrypto.ParsePEMPrivateKey(cert.PrivateKey)
+}
+
+// register ensures the user is registered at the acme server
+func (c *CertStore) register() error {
+	if c.user.Registration != nil {
+		return nil
+	}
+
+	log.Info().Msg("register new user")
+	reg, err := c.client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		log.Err(err).Msg("registration failed")
+		return err
+	}
+	// save this
+	c.user.Registration = reg
+	if err := c.SaveUser(c.user); err != nil {
+		log.Err(err).Msg("could not save user registration")
+		return err
+	}
+	return nil
+}
+
 func (c *CertStore) getStoredCertByCN(r *CertRequest) (*CertificateResource, error) {
 	pair, err := c.storage.Get(r.pathCert())
 	if err != nil {
 		return nil, err
 	}
 
-	cert := new(CertificateResource)
-	if err := json.Unmarshal(pair.Value, cert); err != nil {
+	cert, err := decodeRecord(pair.Value)
+	if err != nil {
 		return nil, err
 	}
-	ok, err := r.matchCertificate(cert)
+	ok, err := r.MatchCertificate(cert)
 	if !ok || err != nil {
 		return nil, err
 	}
+	c.touch(r.pathCert(), cert)
 	return cert, nil
 }
 
+// findStoredCert looks up the index. Without a watch of the storage the index is reloaded on a miss,
+// at most once per reload interval, certificates of other instances are found before a new one is obtained.
 func (c *CertStore) findStoredCert(r *CertRequest) (*CertificateResource, error) {
-	list, err := c.storage.List("certs/")
-	if err != nil {
-		return nil, err
-	}
-
-	for _, pair := range list {
-		cert := new(CertificateResource)
-		if err := json.Unmarshal(pair.Value, cert); err != nil {
-			log.Err(err).Str("cert_key", pair.Key).Msg("Could not decode json from store")
-			continue
+	entry := c.index.find(r, c.selectionPolicy())
+	if entry == nil && !c.watched.Load() && time.Since(c.indexLoaded) > indexReloadInterval {
+		if err := c.index.load(c.storage); err != nil {
+			return nil, err
 		}
+		c.indexLoaded = time.Now()
+		entry = c.index.find(r, c.selectionPolicy())
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	c.touch(entry.key, entry.cert)
+	return entry.cert, nil
+}
 
-		ok, err := r.matchCertificate(cert)
-		if err != nil {
-			log.Err(err).Msg("Unable to find check matched certificate")
-			continue
-		}
-		if ok {
-			return cert, nil
-		}
+func (c *CertStore) selectionPolicy() []SelectionCriterion {
+	if len(c.SelectionPolicy) == 0 {
+		return DefaultSelectionPolicy
+	}
+	return c.SelectionPolicy
+}
 
+// watchIndex keeps the index up to date with changes of other instances
+func (c *CertStore) watchIndex() {
+	events, err := c.storage.WatchTree("certs/", nil)
+	if err == store.ErrCallNotSupported {
+		log.Debug().Msg("storage does not support watch, certificate index is reloaded on demand")
+		return
 	}
-	return nil, nil
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot watch storage, certificate index is reloaded on demand")
+		return
+	}
+	c.watched.Store(true)
+	go func() {
+		for pairs := range events {
+			c.index.update(pairs)
+		}
+		log.Warn().Msg("watch of storage stopped, certificate index is reloaded on demand")
+		c.watched.Store(false)
+	}()
 }
@@ -0,0 +1,124 @@
+package certstore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore/libkv/local"
+	"github.com/project0/certjunkie/internal/testcert"
+)
+
+// fakeCertifier issues self-signed certificates instead of talking to an acme server
+type fakeCertifier struct {
+	t        testing.TB
+	requests []certificate.ObtainRequest
+}
+
+func (f *fakeCertifier) Obtain(request certificate.ObtainRequest) (*certificate.Resource, error) {
+	f.requests = append(f.requests, request)
+	cert, key := testcert.New(f.t, time.Now(), time.Now().Add(90*24*time.Hour), request.Domains...)
+	if request.PrivateKey != nil {
+		// the fake does not sign with the key, only the reuse is of interest
+		key = certcrypto.PEMEncode(request.PrivateKey)
+	}
+	return &certificate.Resource{
+		Domain:      request.Domains[0],
+		Certificate: cert,
+		PrivateKey:  key,
+	}, nil
+}
+
+func (f *fakeCertifier) ObtainForCSR(request certificate.ObtainForCSRRequest) (*certificate.Resource, error) {
+	cert, _ := testcert.New(f.t, time.Now(), time.Now().Add(90*24*time.Hour), request.CSR.DNSNames...)
+	return &certificate.Resource{Domain: request.CSR.DNSNames[0], Certificate: cert}, nil
+}
+
+func newTestCertStore(t *testing.T) (*CertStore, *fakeCertifier) {
+	storage, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	certifier := &fakeCertifier{t: t}
+	return &CertStore{
+		user:      &User{Email: "test@example.com", Registration: &registration.Resource{URI: "https://acme.example/acct/1"}},
+		certifier: certifier,
+		sync:      &sync.Mutex{},
+		storage:   storage,
+		index:     newIndex(),
+	}, certifier
+}
+
+func TestObtainReuseKey(t *testing.T) {
+	c, certifier := newTestCertStore(t)
+	request := &CertRequest{Domain: "example.com", ValidDays: 30}
+
+	first, err := c.obtain(request, true)
+	assert.NoError(t, err)
+	assert.Nil(t, certifier.requests[0].PrivateKey, "nothing to reuse for the first certificate")
+	assert.Equal(t, "https://acme.example/acct/1", first.Metadata.Account)
+
+	renewed, err := c.obtain(request, true)
+	assert.NoError(t, err)
+	assert.NotNil(t, certifier.requests[1].PrivateKey)
+	assert.Equal(t, first.PrivateKey, renewed.PrivateKey)
+
+	fresh, err := c.obtain(request, false)
+	assert.NoError(t, err)
+	assert.Nil(t, certifier.requests[2].PrivateKey)
+	assert.NotEqual(t, first.PrivateKey, fresh.PrivateKey)
+}
+
+func TestStoredPrivateKey(t *testing.T) {
+	c, _ := newTestCertStore(t)
+	request := &CertRequest{Domain: "example.com"}
+
+	key, err := c.storedPrivateKey(request)
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+
+	cert := testCertificate(t, "example.com")
+	putCertificate(t, c.storage, request.pathCert(), cert)
+	key, err = c.storedPrivateKey(request)
+	assert.NoError(t, err)
+	assert.Equal(t, cert.PrivateKey, certcrypto.PEMEncode(key))
+
+	cert.PrivateKey = nil
+	putCertificate(t, c.storage, request.pathCert(), cert)
+	key, err = c.storedPrivateKey(request)
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+
+	assert.NoError(t, c.storage.Put(request.pathCert(), []byte("{"), nil))
+	_, err = c.storedPrivateKey(request)
+	assert.Error(t, err)
+}
+
+func TestRotateKey(t *testing.T) {
+	c, certifier := newTestCertStore(t)
+	c.ReuseKey = true
+	request := &CertRequest{Domain: "example.com", ValidDays: 30}
+
+	// nothing stored yet
+	first, err := c.RotateKey(request)
+	assert.NoError(t, err)
+
+	_, err = c.RotateKey(request)
+	assert.Equal(t, ErrRotatedRecently, err)
+	assert.Len(t, certifier.requests, 1)
+
+	first.Metadata.IssuedAt = time.Now().Add(-2 * rotateInterval)
+	putCertificate(t, c.storage, request.pathCert(), first)
+
+	rotated, err := c.RotateKey(request)
+	assert.NoError(t, err)
+	assert.Nil(t, certifier.requests[1].PrivateKey, "the key is not reused on rotation")
+	assert.NotEqual(t, first.PrivateKey, rotated.PrivateKey)
+
+	stored, err := c.getStoredCertByCN(request)
+	assert.NoError(t, err)
+	assert.Equal(t, rotated.Certificate, stored.Certificate)
+}
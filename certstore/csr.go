@@ -0,0 +1,180 @@
+package certstore
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/rs/zerolog/log"
+)
+
+// CSRRequest contains a certificate signing request, the private key never leaves the requester
+type CSRRequest struct {
+	CSR       *x509.CertificateRequest
+	ValidDays int
+}
+
+// ParseCSR parses a pem (or der) encoded certificate signing request and verifies its signature
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("unexpected pem block type %s", block.Type)
+		}
+		data = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid csr signature: %v", err)
+	}
+	if len(csrNames(csr)) == 0 {
+		return nil, errors.New("csr does not contain any domain")
+	}
+	for _, domain := range csrNames(csr) {
+		if _, err := NormalizeDomain(domain); err != nil {
+			return nil, err
+		}
+	}
+	return csr, nil
+}
+
+// csrNames returns the names of the csr as submitted, the common name comes first
+func csrNames(csr *x509.CertificateRequest) []string {
+	names := []string{}
+	if csr.Subject.CommonName != "" {
+		names = append(names, csr.Subject.CommonName)
+	}
+	return append(names, csr.DNSNames...)
+}
+
+// CSRDomains returns all normalized names of the csr, the common name comes first.
+// The names of a csr returned by ParseCSR are always valid.
+func CSRDomains(csr *x509.CertificateRequest) []string {
+	domains := []string{}
+	for _, name := range csrNames(csr) {
+		domain, err := NormalizeDomain(name)
+		if err != nil {
+			domain = strings.ToLower(name)
+		}
+		domains = append(domains, domain)
+	}
+	return removeDuplicates(domains)
+}
+
+// certRequest describes the names and validity the certificate for the csr needs to have
+func (r *CSRRequest) certRequest() *CertRequest {
+	domains := CSRDomains(r.CSR)
+	return &CertRequest{
+		Domain:    domains[0],
+		San:       domains[1:],
+		ValidDays: r.ValidDays,
+	}
+}
+
+// pathCert is separated from the certs with private keys, they cannot be served to other requests.
+// The hash of the public key keeps requests with different keys for the same domain apart.
+func (r *CSRRequest) pathCert() string {
+	sum := sha256.Sum256(r.CSR.RawSubjectPublicKeyInfo)
+	return "csr/" + CSRDomains(r.CSR)[0] + "-" + hex.EncodeToString(sum[:8]) + ".json"
+}
+
+// GetCertificateForCSR retrieves a certificate for the csr from storage or acme, the private key is not part of the result
+func (c *CertStore) GetCertificateForCSR(request *CSRRequest) (*CertificateResource, error) {
+	c.sync.Lock()
+	defer c.sync.Unlock()
+
+	cert, err := c.getStoredCertForCSR(request)
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	if cert != nil {
+		return cert, nil
+	}
+
+	if err := c.register(); err != nil {
+		return nil, err
+	}
+
+	acmeCerts, err := c.certifier.ObtainForCSR(certificate.ObtainForCSRRequest{
+		CSR:            request.CSR,
+		Bundle:         false,
+		PreferredChain: c.preferredChain,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain new certificate: %v", err)
+	}
+
+	cert = &CertificateResource{
+		Version:           RecordVersion,
+		Domain:            acmeCerts.Domain,
+		Certificate:       acmeCerts.Certificate,
+		IssuerCertificate: acmeCerts.IssuerCertificate,
+		Metadata:          c.metadata(acmeCerts, request.certRequest()),
+	}
+
+	if err := archive(c.storage, request.pathCert(), c.HistoryRetention); err != nil {
+		log.Warn().Err(err).Str("domain", acmeCerts.Domain).Msg("cannot archive replaced certificate")
+	}
+
+	val, _ := json.Marshal(cert)
+	err = c.storage.Put(request.pathCert(), val, nil)
+	if err != nil {
+		log.Err(err).Str("domain", acmeCerts.Domain).Msg("cannot save certificate in storage")
+	}
+
+	return cert, nil
+}
+
+// getStoredCertForCSR returns the stored cert if it has been issued for the same public key
+func (c *CertStore) getStoredCertForCSR(r *CSRRequest) (*CertificateResource, error) {
+	pair, err := c.storage.Get(r.pathCert())
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := decodeRecord(pair.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	certInfo, err := cert.ParseCertificate()
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := certInfo.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(r.CSR.PublicKey) {
+		return nil, nil
+	}
+
+	ok, err = r.certRequest().MatchCertificate(cert)
+	if !ok || err != nil {
+		return nil, err
+	}
+	c.touch(r.pathCert(), cert)
+	return cert, nil
+}
+
+// DomainAllowed checks the domain against a list of patterns, a leading "*." matches all subdomains
+func DomainAllowed(domain string, patterns []string) bool {
+	domain = strings.ToLower(domain)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == domain {
+			return true
+		}
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(domain, pattern[1:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package certstore
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCSR(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "example.com"},
+		DNSNames: []string{"example.com", "www.example.com"},
+	}, key)
+	assert.NoError(t, err)
+
+	csr, err := ParseCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, CSRDomains(csr))
+	sum := sha256.Sum256(csr.RawSubjectPublicKeyInfo)
+	keyHash := hex.EncodeToString(sum[:8])
+	assert.Equal(t, "csr/example.com-"+keyHash+".json", (&CSRRequest{CSR: csr}).pathCert())
+
+	// the key of idn and trailing dot names matches the key of the cert request
+	der, err = x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "Bücher.example."},
+		DNSNames: []string{"xn--bcher-kva.example"},
+	}, key)
+	assert.NoError(t, err)
+	idn, err := ParseCSR(der)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"xn--bcher-kva.example"}, CSRDomains(idn))
+	assert.Equal(t, "csr/xn--bcher-kva.example-"+keyHash+".json", (&CSRRequest{CSR: idn}).pathCert())
+
+	// another key for the same domain is stored separately
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err = x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "example.com"},
+		DNSNames: []string{"example.com", "www.example.com"},
+	}, other)
+	assert.NoError(t, err)
+	otherCSR, err := ParseCSR(der)
+	assert.NoError(t, err)
+	assert.NotEqual(t, (&CSRRequest{CSR: csr}).pathCert(), (&CSRRequest{CSR: otherCSR}).pathCert())
+
+	_, err = ParseCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	assert.Error(t, err)
+
+	// broken signature
+	der[len(der)-1] ^= 0xff
+	_, err = ParseCSR(der)
+	assert.Error(t, err)
+}
+
+func TestDomainAllowed(t *testing.T) {
+	patterns := []string{"example.com", "*.internal.example.org"}
+
+	assert.True(t, DomainAllowed("example.com", patterns))
+	assert.True(t, DomainAllowed("EXAMPLE.com", patterns))
+	assert.True(t, DomainAllowed("a.internal.example.org", patterns))
+	assert.True(t, DomainAllowed("*.internal.example.org", patterns))
+	assert.True(t, DomainAllowed("a.b.internal.example.org", patterns))
+	assert.False(t, DomainAllowed("www.example.com", patterns))
+	assert.False(t, DomainAllowed("internal.example.org", patterns))
+	assert.False(t, DomainAllowed("evilinternal.example.org", patterns))
+}
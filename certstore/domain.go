@@ -0,0 +1,77 @@
+package certstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrInvalidDomain is returned for domain names a certificate cannot be requested for
+var ErrInvalidDomain = errors.New("invalid domain name")
+
+// NormalizeDomain converts the domain to its lower case ASCII (punycode) form and validates it.
+// A wildcard is only allowed as the leftmost label.
+func NormalizeDomain(domain string) (string, error) {
+	name := strings.TrimSuffix(domain, ".")
+	wildcard := strings.HasPrefix(name, "*.")
+	if wildcard {
+		name = name[2:]
+	}
+
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrInvalidDomain, domain, err)
+	}
+	if len(ascii) > 253 {
+		return "", fmt.Errorf("%w %q: name is too long", ErrInvalidDomain, domain)
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if err := validLabel(label); err != nil {
+			return "", fmt.Errorf("%w %q: %v", ErrInvalidDomain, domain, err)
+		}
+	}
+	if wildcard {
+		if !strings.Contains(ascii, ".") {
+			return "", fmt.Errorf("%w %q: wildcard needs at least two labels", ErrInvalidDomain, domain)
+		}
+		return "*." + ascii, nil
+	}
+	return ascii, nil
+}
+
+// validLabel checks the label against the hostname rules of RFC 1123
+func validLabel(label string) error {
+	if len(label) == 0 || len(label) > 63 {
+		return fmt.Errorf("label %q must have 1 to 63 characters", label)
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return fmt.Errorf("label %q must not start or end with a hyphen", label)
+	}
+	for _, r := range label {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return fmt.Errorf("label %q contains invalid character %q", label, r)
+		}
+	}
+	return nil
+}
+
+// Normalize validates and normalizes all domains of the request
+func (r *CertRequest) Normalize() error {
+	domain, err := NormalizeDomain(r.Domain)
+	if err != nil {
+		return err
+	}
+	san := make([]string, 0, len(r.San))
+	for _, name := range r.San {
+		normalized, err := NormalizeDomain(name)
+		if err != nil {
+			return err
+		}
+		san = append(san, normalized)
+	}
+	r.Domain = domain
+	r.San = san
+	return nil
+}
@@ -0,0 +1,61 @@
+package certstore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	valid := map[string]string{
+		"example.com":       "example.com",
+		"WWW.Example.COM.":  "www.example.com",
+		"*.example.com":     "*.example.com",
+		"bücher.example":    "xn--bcher-kva.example",
+		"*.BÜCHER.example":  "*.xn--bcher-kva.example",
+		"a-b.example.com":   "a-b.example.com",
+		"xn--bcher-kva.com": "xn--bcher-kva.com",
+	}
+	for domain, expected := range valid {
+		normalized, err := NormalizeDomain(domain)
+		assert.NoError(t, err, domain)
+		assert.Equal(t, expected, normalized)
+	}
+
+	hostile := []string{
+		"",
+		".",
+		"..",
+		"../../etc/passwd",
+		"..example.com",
+		"example.com/../../user",
+		"example..com",
+		`example.com\..\user`,
+		"example.com%2f..",
+		"foo bar.com",
+		"-example.com",
+		"example-.com",
+		"under_score.com",
+		"*",
+		"*.com.*",
+		"www.*.example.com",
+		"**.example.com",
+		"example.com\x00.json",
+		"a123456789012345678901234567890123456789012345678901234567890123.com",
+	}
+	for _, domain := range hostile {
+		_, err := NormalizeDomain(domain)
+		assert.True(t, errors.Is(err, ErrInvalidDomain), "%q: %v", domain, err)
+	}
+}
+
+func TestCertRequestNormalize(t *testing.T) {
+	r := &CertRequest{Domain: "Example.com", San: []string{"WWW.example.com"}}
+	assert.NoError(t, r.Normalize())
+	assert.Equal(t, "certs/example.com.json", r.pathCert())
+	assert.Equal(t, []string{"www.example.com"}, r.San)
+
+	r = &CertRequest{Domain: "example.com", San: []string{"../user"}}
+	assert.Error(t, r.Normalize())
+}
@@ -0,0 +1,365 @@
+package certstore
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// encryptedField is the json field of User and CertificateResource containing the private key
+	encryptedField = "key"
+	// envelopeVersion binds the encrypted data to the storage key, envelopes without version are not bound
+	envelopeVersion = 1
+)
+
+// Keyring contains the key encryption keys by id, the first key is used to encrypt new data
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// ParseKeyring reads keys in the format "id:base64(32 byte key)", one per line or comma separated.
+// Empty lines and lines starting with # are ignored.
+func ParseKeyring(data string) (*Keyring, error) {
+	k := &Keyring{keys: map[string][]byte{}}
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(data, ",", "\n")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, ":")
+		if !ok || id == "" {
+			return nil, errors.New("invalid key, expected format id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %v", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid key %s: expected 32 bytes, got %d", id, len(key))
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate key id %s", id)
+		}
+		if k.active == "" {
+			k.active = id
+		}
+		k.keys[id] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if k.active == "" {
+		return nil, errors.New("no encryption key found")
+	}
+	return k, nil
+}
+
+// LoadKeyring reads the keyring from file
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyring(string(data))
+}
+
+// envelope is the encrypted private key, the data key is encrypted with the key encryption key kid
+type envelope struct {
+	Version int    `json:"v,omitempty"`
+	KeyID   string `json:"kid"`
+	// DataKey is the nonce and encrypted data key
+	DataKey []byte `json:"dek"`
+	// Data is the nonce and encrypted private key
+	Data []byte `json:"data"`
+}
+
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], additionalData)
+}
+
+// recordKey is the storage key authenticated with the data, it cannot be moved to another key
+func recordKey(key string) []byte {
+	return []byte(strings.Trim(key, "/"))
+}
+
+func (k *Keyring) encrypt(key string, plaintext []byte) (*envelope, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	data, err := seal(dataKey, plaintext, recordKey(key))
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return nil, err
+	}
+	return &envelope{Version: envelopeVersion, KeyID: k.active, DataKey: wrapped, Data: data}, nil
+}
+
+func (k *Keyring) decrypt(key string, e *envelope) ([]byte, error) {
+	if e.Version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", e.Version)
+	}
+	kek, ok := k.keys[e.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key id %s", e.KeyID)
+	}
+	dataKey, err := open(kek, e.DataKey, []byte(e.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt data key: %v", err)
+	}
+	return open(dataKey, e.Data, recordKey(key))
+}
+
+// EncryptedStore encrypts the private keys of users and certificates before passing them to the wrapped store.
+// Other values and unencrypted records (written before encryption has been enabled) are passed through.
+type EncryptedStore struct {
+	store.Store
+	keyring *Keyring
+}
+
+// NewEncryptedStore wraps the storage
+func NewEncryptedStore(storage store.Store, keyring *Keyring) *EncryptedStore {
+	return &EncryptedStore{
+		Store:   storage,
+		keyring: keyring,
+	}
+}
+
+// encryptValue replaces the private key field of a json record with its envelope
+func (s *EncryptedStore) encryptValue(key string, value []byte) ([]byte, error) {
+	record := map[string]json.RawMessage{}
+	if err := json.Unmarshal(value, &record); err != nil {
+		// not a json object, nothing to encrypt
+		return value, nil
+	}
+	field, ok := record[encryptedField]
+	if !ok {
+		return value, nil
+	}
+
+	var plaintext []byte
+	if err := json.Unmarshal(field, &plaintext); err != nil || plaintext == nil {
+		// already encrypted or empty
+		return value, nil
+	}
+
+	e, err := s.keyring.encrypt(key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if record[encryptedField], err = json.Marshal(e); err != nil {
+		return nil, err
+	}
+	return json.Marshal(record)
+}
+
+// decryptValue replaces the envelope of a json record with the decrypted private key
+func (s *EncryptedStore) decryptValue(key string, value []byte) ([]byte, error) {
+	record := map[string]json.RawMessage{}
+	if err := json.Unmarshal(value, &record); err != nil {
+		return value, nil
+	}
+	field, ok := record[encryptedField]
+	if !ok {
+		return value, nil
+	}
+
+	e := &envelope{}
+	if err := json.Unmarshal(field, e); err != nil || e.KeyID == "" {
+		// plain text record
+		return value, nil
+	}
+
+	plaintext, err := s.keyring.decrypt(key, e)
+	if err != nil {
+		return nil, err
+	}
+	if record[encryptedField], err = json.Marshal(plaintext); err != nil {
+		return nil, err
+	}
+	return json.Marshal(record)
+}
+
+func (s *EncryptedStore) decryptPair(pair *store.KVPair) (*store.KVPair, error) {
+	if pair == nil {
+		return nil, nil
+	}
+	value, err := s.decryptValue(pair.Key, pair.Value)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt %s: %v", pair.Key, err)
+	}
+	return &store.KVPair{Key: pair.Key, Value: value, LastIndex: pair.LastIndex}, nil
+}
+
+// Put encrypts and writes the value
+func (s *EncryptedStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	value, err := s.encryptValue(key, value)
+	if err != nil {
+		return err
+	}
+	return s.Store.Put(key, value, options)
+}
+
+// Get reads and decrypts the value
+func (s *EncryptedStore) Get(key string) (*store.KVPair, error) {
+	pair, err := s.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptPair(pair)
+}
+
+// List reads and decrypts all values of the directory, records which cannot be decrypted are skipped
+func (s *EncryptedStore) List(directory string) ([]*store.KVPair, error) {
+	pairs, err := s.Store.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*store.KVPair, 0, len(pairs))
+	for _, pair := range pairs {
+		decrypted, err := s.decryptPair(pair)
+		if err != nil {
+			log.Warn().Err(err).Str("key", pair.Key).Msg("skip record which cannot be decrypted")
+			continue
+		}
+		result = append(result, decrypted)
+	}
+	return result, nil
+}
+
+// AtomicPut encrypts the value and writes it if the previous value has not been changed
+func (s *EncryptedStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	encrypted, err := s.encryptValue(key, value)
+	if err != nil {
+		return false, nil, err
+	}
+	ok, pair, err := s.Store.AtomicPut(key, encrypted, previous, options)
+	if pair != nil {
+		pair = &store.KVPair{Key: pair.Key, Value: value, LastIndex: pair.LastIndex}
+	}
+	return ok, pair, err
+}
+
+// AtomicDelete is passed through, the backends only compare the LastIndex of the previous pair which is kept by decryption
+func (s *EncryptedStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return s.Store.AtomicDelete(key, previous)
+}
+
+// Watch decrypts the values of the wrapped watch
+func (s *EncryptedStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	events, err := s.Store.Watch(key, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		for pair := range events {
+			decrypted, err := s.decryptPair(pair)
+			if err != nil {
+				continue
+			}
+			select {
+			case out <- decrypted:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree decrypts the values of the wrapped watch
+func (s *EncryptedStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	events, err := s.Store.WatchTree(directory, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		for pairs := range events {
+			decrypted := make([]*store.KVPair, 0, len(pairs))
+			for _, pair := range pairs {
+				if pair, err := s.decryptPair(pair); err == nil {
+					decrypted = append(decrypted, pair)
+				}
+			}
+			select {
+			case out <- decrypted:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Reencrypt rewrites the records of the user and all certificates with the active key.
+// Records written before encryption has been enabled are encrypted.
+func (s *EncryptedStore) Reencrypt() (int, error) {
+	keys, err := storedKeys(s.Store)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, key := range keys {
+		pair, err := s.Get(key)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		if err := s.Put(key, pair.Value, nil); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
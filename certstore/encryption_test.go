@@ -0,0 +1,138 @@
+package certstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore/libkv/local"
+)
+
+func testKey(t *testing.T, id string) string {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func TestParseKeyring(t *testing.T) {
+	k, err := ParseKeyring("# comment\n" + testKey(t, "new") + "\n\n" + testKey(t, "old"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new", k.active)
+	assert.Len(t, k.keys, 2)
+
+	_, err = ParseKeyring("")
+	assert.Error(t, err)
+	_, err = ParseKeyring("short:" + base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+	key := testKey(t, "dup")
+	_, err = ParseKeyring(key + "," + key)
+	assert.Error(t, err)
+}
+
+func TestEncryptedStore(t *testing.T) {
+	backend, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	oldKey := testKey(t, "v1")
+	oldRing, err := ParseKeyring(oldKey)
+	assert.NoError(t, err)
+
+	cert := &CertificateResource{Domain: "example.com", PrivateKey: []byte("secret key"), Certificate: []byte("cert")}
+	value, _ := json.Marshal(cert)
+
+	// written before encryption has been enabled
+	assert.NoError(t, backend.Put("certs/plain.json", value, nil))
+
+	s := NewEncryptedStore(backend, oldRing)
+	assert.NoError(t, s.Put("certs/example.com.json", value, nil))
+
+	raw, err := backend.Get("certs/example.com.json")
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(raw.Value, []byte(base64.StdEncoding.EncodeToString(cert.PrivateKey))))
+	assert.Contains(t, string(raw.Value), `"kid":"v1"`)
+
+	for _, key := range []string{"certs/example.com.json", "certs/plain.json"} {
+		pair, err := s.Get(key)
+		assert.NoError(t, err)
+		stored := &CertificateResource{}
+		assert.NoError(t, json.Unmarshal(pair.Value, stored))
+		assert.Equal(t, cert, stored)
+	}
+
+	// rotate the key, old records are still readable until reencrypted
+	rotated, err := ParseKeyring(testKey(t, "v2") + "," + oldKey)
+	assert.NoError(t, err)
+	s = NewEncryptedStore(backend, rotated)
+	pairs, err := s.List("certs/")
+	assert.NoError(t, err)
+	assert.Len(t, pairs, 2)
+
+	count, err := s.Reencrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	for _, key := range []string{"certs/example.com.json", "certs/plain.json"} {
+		raw, err := backend.Get(key)
+		assert.NoError(t, err)
+		assert.Contains(t, string(raw.Value), `"kid":"v2"`)
+	}
+
+	// the old key is not required anymore, a wrong key fails
+	s = NewEncryptedStore(backend, oldRing)
+	_, err = s.Get("certs/example.com.json")
+	assert.Error(t, err)
+}
+
+func TestEncryptedStoreBindsKey(t *testing.T) {
+	backend, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	ring, err := ParseKeyring(testKey(t, "v1"))
+	assert.NoError(t, err)
+	s := NewEncryptedStore(backend, ring)
+
+	value, _ := json.Marshal(&CertificateResource{Domain: "a.example.com", PrivateKey: []byte("secret key")})
+	assert.NoError(t, s.Put("certs/a.example.com.json", value, nil))
+
+	// the envelope of another record cannot be swapped in
+	raw, err := backend.Get("certs/a.example.com.json")
+	assert.NoError(t, err)
+	assert.NoError(t, backend.Put("certs/b.example.com.json", raw.Value, nil))
+	_, err = s.Get("certs/b.example.com.json")
+	assert.Error(t, err)
+	pairs, err := s.List("certs/")
+	assert.NoError(t, err)
+	assert.Len(t, pairs, 1)
+	assert.Equal(t, "/certs/a.example.com.json", pairs[0].Key)
+
+	pair, err := s.Get("/certs/a.example.com.json")
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(value), string(pair.Value))
+
+	ok, err := s.AtomicDelete("certs/a.example.com.json", pair)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestEncryptedStoreRejectsUnversionedEnvelope(t *testing.T) {
+	backend, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	ring, err := ParseKeyring(testKey(t, "v1"))
+	assert.NoError(t, err)
+	s := NewEncryptedStore(backend, ring)
+
+	// the data of an envelope without version is not bound to the key, it could have been moved from another record
+	dataKey := make([]byte, 32)
+	data, err := seal(dataKey, []byte("secret key"), nil)
+	assert.NoError(t, err)
+	wrapped, err := seal(ring.keys["v1"], dataKey, []byte("v1"))
+	assert.NoError(t, err)
+	value, _ := json.Marshal(map[string]interface{}{"domain": "example.com", "key": &envelope{KeyID: "v1", DataKey: wrapped, Data: data}})
+	assert.NoError(t, backend.Put("certs/example.com.json", value, nil))
+
+	_, err = s.Get("certs/example.com.json")
+	assert.Error(t, err)
+	pairs, err := s.List("certs/")
+	assert.NoError(t, err)
+	assert.Empty(t, pairs)
+}
@@ -0,0 +1,273 @@
+package certstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// accessInterval limits the writes of the last accessed time of a certificate
+	accessInterval = 24 * time.Hour
+	// accessFlushInterval is the delay of writing recorded accesses to the storage
+	accessFlushInterval = time.Minute
+)
+
+// gcDirectories contain the certificate records collected by the garbage collection
+var gcDirectories = []string{"certs/", "csr/"}
+
+// GCPolicy decides which certificates are removed by the garbage collection
+type GCPolicy struct {
+	// ExpiredFor removes certificates expired longer, 0 disables it
+	ExpiredFor time.Duration
+	// UnusedFor removes certificates which have not been requested for longer, 0 disables it
+	UnusedFor time.Duration
+	// Archive moves the certificates to the history instead of deleting them
+	Archive bool
+	// DryRun only reports the certificates which would be removed
+	DryRun bool
+}
+
+// GCResult is a certificate removed by the garbage collection
+type GCResult struct {
+	Key          string    `json:"key"`
+	Reason       string    `json:"reason"`
+	NotAfter     time.Time `json:"notafter"`
+	LastAccessed time.Time `json:"lastaccessed"`
+}
+
+// lastAccessed returns the last request of the cert, zero if it is unknown (records written before the tracking)
+func lastAccessed(cert *CertificateResource) time.Time {
+	if cert.Metadata == nil {
+		return time.Time{}
+	}
+	return cert.Metadata.LastAccessed
+}
+
+// touch records the access of the stored certificate, it is written by flushAccess at most once per access interval
+func (c *CertStore) touch(key string, cert *CertificateResource) {
+	if time.Since(lastAccessed(cert)) < accessInterval {
+		return
+	}
+	c.accessLock.Lock()
+	defer c.accessLock.Unlock()
+	if c.accessed == nil {
+		c.accessed = map[string]time.Time{}
+	}
+	if _, ok := c.accessed[key]; !ok {
+		c.accessed[key] = time.Now().UTC()
+	}
+}
+
+// flushAccess writes the recorded accesses to the stored certificates
+func (c *CertStore) flushAccess() {
+	c.accessLock.Lock()
+	accessed := c.accessed
+	c.accessed = nil
+	c.accessLock.Unlock()
+
+	for key, at := range accessed {
+		value, err := setLastAccessed(c.storage, key, at)
+		if err != nil {
+			log.Warn().Err(err).Str("cert_key", key).Msg("cannot update last accessed time of certificate")
+			continue
+		}
+		if value == nil || !strings.HasPrefix(key, "certs/") {
+			continue
+		}
+		if err := c.index.put(key, value); err != nil {
+			log.Err(err).Str("cert_key", key).Msg("cannot index certificate")
+		}
+	}
+}
+
+// setLastAccessed updates the last accessed time of the stored certificate if it is newer.
+// It returns the written value, nil if the certificate has been removed or changed concurrently.
+func setLastAccessed(storage store.Store, key string, at time.Time) ([]byte, error) {
+	pair, err := storage.Get(key)
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cert, err := decodeRecord(pair.Value)
+	if err != nil {
+		return nil, err
+	}
+	if !lastAccessed(cert).Before(at) {
+		return nil, nil
+	}
+	if cert.Metadata == nil {
+		cert.Metadata = &CertificateMetadata{}
+	}
+	cert.Metadata.LastAccessed = at
+
+	value, _ := json.Marshal(cert)
+	_, _, err = storage.AtomicPut(key, value, pair, nil)
+	if err == store.ErrCallNotSupported {
+		err = storage.Put(key, value, nil)
+	}
+	if err == store.ErrKeyModified || err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// gcReason returns why the certificate is collected, empty if it is kept
+func (p GCPolicy) gcReason(cert *CertificateResource, now time.Time) (string, error) {
+	info, err := cert.ParseCertificate()
+	if err != nil {
+		return "", err
+	}
+	if p.ExpiredFor > 0 && now.Sub(info.NotAfter) > p.ExpiredFor {
+		return fmt.Sprintf("expired at %s", info.NotAfter.Format(time.RFC3339)), nil
+	}
+	accessed := lastAccessed(cert)
+	if p.UnusedFor > 0 && now.Sub(accessed) > p.UnusedFor {
+		return fmt.Sprintf("not requested since %s", accessed.Format(time.RFC3339)), nil
+	}
+	return "", nil
+}
+
+// CollectGarbage removes the certificates matching the policy, archived certificates are kept up to the retention.
+// Certificates without last accessed time get the current time and are only removed if they have been requested
+// for the unused duration since. A certificate changed during the collection is not removed if the storage supports AtomicDelete.
+func CollectGarbage(storage store.Store, policy GCPolicy, retention int) ([]*GCResult, error) {
+	if policy.Archive && retention <= 0 {
+		return nil, fmt.Errorf("certificates cannot be archived, the history is disabled")
+	}
+	now := time.Now()
+	results := []*GCResult{}
+	for _, directory := range gcDirectories {
+		list, err := storage.List(directory)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return results, err
+		}
+
+		for _, pair := range list {
+			key := indexKey(pair.Key)
+			cert, err := decodeRecord(pair.Value)
+			if err != nil {
+				log.Err(err).Str("cert_key", key).Msg("Could not decode stored certificate")
+				continue
+			}
+			if policy.UnusedFor > 0 && lastAccessed(cert).IsZero() {
+				// the last request is unknown, the unused duration starts now
+				if !policy.DryRun {
+					value, err := setLastAccessed(storage, key, now.UTC())
+					if err != nil {
+						log.Warn().Err(err).Str("cert_key", key).Msg("cannot update last accessed time of certificate")
+					}
+					if value != nil {
+						pair = &store.KVPair{Key: pair.Key, Value: value}
+					}
+				}
+				if cert.Metadata == nil {
+					cert.Metadata = &CertificateMetadata{}
+				}
+				cert.Metadata.LastAccessed = now
+			}
+			reason, err := policy.gcReason(cert, now)
+			if err != nil {
+				log.Err(err).Str("cert_key", key).Msg("Could not parse stored certificate")
+				continue
+			}
+			if reason == "" {
+				continue
+			}
+
+			result := &GCResult{Key: key, Reason: reason, LastAccessed: lastAccessed(cert)}
+			if info, err := cert.ParseCertificate(); err == nil {
+				result.NotAfter = info.NotAfter
+			}
+			log.Info().Str("cert_key", key).Str("reason", reason).Bool("dry_run", policy.DryRun).Bool("archive", policy.Archive).Msg("collect certificate")
+			if policy.DryRun {
+				results = append(results, result)
+				continue
+			}
+
+			if policy.Archive {
+				if err := archive(storage, key, retention); err != nil {
+					return results, fmt.Errorf("cannot archive %s: %v", key, err)
+				}
+			}
+			if err := deleteUnchanged(storage, key, pair); err != nil {
+				if err == store.ErrKeyModified || err == store.ErrKeyNotFound {
+					log.Info().Str("cert_key", key).Msg("certificate has been changed, keep it")
+					continue
+				}
+				return results, fmt.Errorf("cannot delete %s: %v", key, err)
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// deleteUnchanged deletes the key only if it has not been changed since it has been listed
+func deleteUnchanged(storage store.Store, key string, listed *store.KVPair) error {
+	current, err := storage.Get(key)
+	if err != nil {
+		return err
+	}
+	if !sameRecord(current.Value, listed.Value) {
+		return store.ErrKeyModified
+	}
+	_, err = storage.AtomicDelete(key, current)
+	if err == store.ErrCallNotSupported {
+		return storage.Delete(key)
+	}
+	return err
+}
+
+// CollectGarbage removes the certificates matching the policy from the storage and the index
+func (c *CertStore) CollectGarbage(policy GCPolicy) ([]*GCResult, error) {
+	// recent requests keep the certificates
+	c.flushAccess()
+
+	c.sync.Lock()
+	defer c.sync.Unlock()
+
+	results, err := CollectGarbage(c.storage, policy, c.HistoryRetention)
+	if !policy.DryRun {
+		for _, result := range results {
+			c.index.delete(result.Key)
+		}
+	}
+	return results, err
+}
+
+// StartGarbageCollection runs the garbage collection in the interval
+func (c *CertStore) StartGarbageCollection(policy GCPolicy, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			results, err := c.CollectGarbage(policy)
+			if err != nil {
+				log.Err(err).Msg("garbage collection failed")
+			}
+			log.Info().Int("certificates", len(results)).Bool("dry_run", policy.DryRun).Msg("garbage collection finished")
+		}
+	}()
+}
+
+// flushAccessLoop writes the recorded accesses in the flush interval
+func (c *CertStore) flushAccessLoop() {
+	ticker := time.NewTicker(accessFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.flushAccess()
+	}
+}
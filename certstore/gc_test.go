@@ -0,0 +1,126 @@
+package certstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore/libkv/local"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	storage, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	now := time.Now()
+
+	expired := testCertificateValidity(t, now.Add(-60*24*time.Hour), now.Add(-10*24*time.Hour), "expired.example.com")
+	putCertificate(t, storage, "certs/expired.example.com.json", expired)
+	recentlyExpired := testCertificateValidity(t, now.Add(-60*24*time.Hour), now.Add(-time.Hour), "recent.example.com")
+	recentlyExpired.Metadata = &CertificateMetadata{LastAccessed: now.Add(-2 * time.Hour)}
+	putCertificate(t, storage, "certs/recent.example.com.json", recentlyExpired)
+	unused := testCertificateValidity(t, now.Add(-40*24*time.Hour), now.Add(40*24*time.Hour), "preview.example.com")
+	unused.Metadata = &CertificateMetadata{LastAccessed: now.Add(-20 * 24 * time.Hour)}
+	putCertificate(t, storage, "certs/preview.example.com.json", unused)
+	used := testCertificateValidity(t, now.Add(-40*24*time.Hour), now.Add(40*24*time.Hour), "www.example.com")
+	used.Metadata = &CertificateMetadata{LastAccessed: now.Add(-time.Hour)}
+	putCertificate(t, storage, "certs/www.example.com.json", used)
+
+	policy := GCPolicy{ExpiredFor: 7 * 24 * time.Hour, UnusedFor: 14 * 24 * time.Hour, DryRun: true}
+	results, err := CollectGarbage(storage, policy, 5)
+	assert.NoError(t, err)
+	keys := []string{}
+	for _, result := range results {
+		keys = append(keys, result.Key)
+	}
+	assert.ElementsMatch(t, []string{"certs/expired.example.com.json", "certs/preview.example.com.json"}, keys)
+	exists, _ := storage.Exists("certs/expired.example.com.json")
+	assert.True(t, exists)
+
+	_, err = CollectGarbage(storage, GCPolicy{Archive: true}, 0)
+	assert.Error(t, err)
+
+	policy.DryRun = false
+	policy.Archive = true
+	results, err = CollectGarbage(storage, policy, 5)
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	for _, key := range []string{"certs/expired.example.com.json", "certs/preview.example.com.json"} {
+		exists, _ := storage.Exists(key)
+		assert.False(t, exists, key)
+	}
+	for _, key := range []string{"certs/recent.example.com.json", "certs/www.example.com.json"} {
+		exists, _ := storage.Exists(key)
+		assert.True(t, exists, key)
+	}
+	history, err := History(storage, "certs/preview.example.com.json")
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+}
+
+func TestCollectGarbageUnknownAccess(t *testing.T) {
+	storage, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	now := time.Now()
+
+	legacy := testCertificateValidity(t, now.Add(-60*24*time.Hour), now.Add(30*24*time.Hour), "legacy.example.com")
+	legacy.Version = 0
+	legacy.Metadata = nil
+	putCertificate(t, storage, "certs/legacy.example.com.json", legacy)
+	imported := testCertificateValidity(t, now.Add(-60*24*time.Hour), now.Add(30*24*time.Hour), "imported.example.com")
+	imported.Metadata = &CertificateMetadata{IssuedAt: now.Add(-60 * 24 * time.Hour), Imported: true}
+	putCertificate(t, storage, "certs/imported.example.com.json", imported)
+
+	policy := GCPolicy{UnusedFor: 14 * 24 * time.Hour, DryRun: true}
+	results, err := CollectGarbage(storage, policy, 5)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+	pair, _ := storage.Get("certs/legacy.example.com.json")
+	stored, _ := decodeRecord(pair.Value)
+	assert.True(t, stored.Metadata.LastAccessed.IsZero())
+
+	// the unused duration starts with the first collection
+	policy.DryRun = false
+	results, err = CollectGarbage(storage, policy, 5)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+	for _, key := range []string{"certs/legacy.example.com.json", "certs/imported.example.com.json"} {
+		pair, err := storage.Get(key)
+		assert.NoError(t, err)
+		stored, _ := decodeRecord(pair.Value)
+		assert.WithinDuration(t, now, stored.Metadata.LastAccessed, time.Minute, key)
+	}
+
+	// expired certs are removed regardless of the unknown access
+	expired := testCertificateValidity(t, now.Add(-60*24*time.Hour), now.Add(-10*24*time.Hour), "expired.example.com")
+	expired.Metadata = nil
+	putCertificate(t, storage, "certs/expired.example.com.json", expired)
+	results, err = CollectGarbage(storage, GCPolicy{ExpiredFor: 7 * 24 * time.Hour, UnusedFor: 14 * 24 * time.Hour}, 5)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+}
+
+func TestTouch(t *testing.T) {
+	storage, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	c := &CertStore{storage: storage, index: newIndex()}
+	putCertificate(t, storage, "certs/example.com.json", testCertificate(t, "example.com"))
+	written, _ := storage.Get("certs/example.com.json")
+
+	// the access is recorded in memory
+	cert, err := c.findStoredCert(&CertRequest{Domain: "example.com"})
+	assert.NoError(t, err)
+	assert.NotNil(t, cert)
+	first, _ := storage.Get("certs/example.com.json")
+	assert.Equal(t, written.LastIndex, first.LastIndex)
+
+	c.flushAccess()
+	first, _ = storage.Get("certs/example.com.json")
+	stored, _ := decodeRecord(first.Value)
+	assert.WithinDuration(t, time.Now(), stored.Metadata.LastAccessed, time.Minute)
+
+	// not written again within the access interval
+	_, err = c.findStoredCert(&CertRequest{Domain: "example.com"})
+	assert.NoError(t, err)
+	c.flushAccess()
+	second, _ := storage.Get("certs/example.com.json")
+	assert.Equal(t, first.LastIndex, second.LastIndex)
+}
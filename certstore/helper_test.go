@@ -0,0 +1,30 @@
+package certstore
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/internal/testcert"
+)
+
+func putCertificate(t testing.TB, storage store.Store, key string, cert *CertificateResource) {
+	value, _ := json.Marshal(cert)
+	assert.NoError(t, storage.Put(key, value, nil))
+}
+
+func testCertificate(t testing.TB, domain string, san ...string) *CertificateResource {
+	return testCertificateValidity(t, time.Now(), time.Now().Add(time.Hour), domain, san...)
+}
+
+func testCertificateValidity(t testing.TB, notBefore time.Time, notAfter time.Time, domain string, san ...string) *CertificateResource {
+	cert, key := testcert.New(t, notBefore, notAfter, append([]string{domain}, san...)...)
+	return &CertificateResource{
+		Domain:      domain,
+		Certificate: cert,
+		PrivateKey:  key,
+	}
+}
@@ -0,0 +1,147 @@
+package certstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/rs/zerolog/log"
+)
+
+// historyDirectory contains the replaced certificates
+const historyDirectory = "history/"
+
+var validHistoryID = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// HistoryEntry is an archived version of a stored cert, the id is the serial number of the cert
+type HistoryEntry struct {
+	ID        string               `json:"id"`
+	NotBefore time.Time            `json:"notbefore"`
+	NotAfter  time.Time            `json:"notafter"`
+	Issuer    string               `json:"issuer"`
+	Metadata  *CertificateMetadata `json:"metadata,omitempty"`
+	// Certificate is not part of the json, it contains the private key
+	Certificate *CertificateResource `json:"-"`
+}
+
+// historyPath returns the directory of the archived versions of the stored cert
+func historyPath(key string) string {
+	return historyDirectory + strings.TrimSuffix(indexKey(key), ".json") + "/"
+}
+
+func newHistoryEntry(value []byte) (*HistoryEntry, error) {
+	cert, err := decodeRecord(value)
+	if err != nil {
+		return nil, err
+	}
+	info, err := cert.ParseCertificate()
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryEntry{
+		ID:          info.SerialNumber.Text(16),
+		NotBefore:   info.NotBefore,
+		NotAfter:    info.NotAfter,
+		Issuer:      info.Issuer.CommonName,
+		Metadata:    cert.Metadata,
+		Certificate: cert,
+	}, nil
+}
+
+// History returns the archived versions of the stored cert, newest first
+func History(storage store.Store, key string) ([]*HistoryEntry, error) {
+	list, err := storage.List(historyPath(key))
+	if err == store.ErrKeyNotFound {
+		return []*HistoryEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*HistoryEntry{}
+	for _, pair := range list {
+		entry, err := newHistoryEntry(pair.Value)
+		if err != nil {
+			log.Err(err).Str("cert_key", pair.Key).Msg("Could not decode archived certificate")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(a, b int) bool { return entries[a].NotBefore.After(entries[b].NotBefore) })
+	return entries, nil
+}
+
+// archive copies the stored cert to its history and removes the oldest versions beyond the retention.
+// A retention of 0 disables the history.
+func archive(storage store.Store, key string, retention int) error {
+	if retention <= 0 {
+		return nil
+	}
+	pair, err := storage.Get(key)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entry, err := newHistoryEntry(pair.Value)
+	if err != nil {
+		return fmt.Errorf("cannot decode stored certificate: %v", err)
+	}
+	if err := storage.Put(historyPath(key)+entry.ID+".json", pair.Value, nil); err != nil {
+		return err
+	}
+
+	entries, err := History(storage, key)
+	if err != nil {
+		return err
+	}
+	for _, old := range entries[min(retention, len(entries)):] {
+		if err := storage.Delete(historyPath(key) + old.ID + ".json"); err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+		log.Debug().Str("cert_key", key).Str("id", old.ID).Msg("removed archived certificate beyond retention")
+	}
+	return nil
+}
+
+// Restore replaces the stored cert with the archived version, the replaced cert is archived as well
+func Restore(storage store.Store, key string, id string, retention int) (*CertificateResource, error) {
+	if !validHistoryID.MatchString(id) {
+		return nil, fmt.Errorf("invalid history id %q", id)
+	}
+	pair, err := storage.Get(historyPath(key) + id + ".json")
+	if err != nil {
+		return nil, err
+	}
+	entry, err := newHistoryEntry(pair.Value)
+	if err != nil {
+		return nil, err
+	}
+	if entry.NotAfter.Before(time.Now()) {
+		return nil, fmt.Errorf("archived certificate %s expired at %s", id, entry.NotAfter.Format(time.RFC3339))
+	}
+
+	if err := archive(storage, key, retention); err != nil {
+		return nil, fmt.Errorf("cannot archive current certificate: %v", err)
+	}
+	value, _ := json.Marshal(entry.Certificate)
+	if err := storage.Put(key, value, nil); err != nil {
+		return nil, err
+	}
+	log.Info().Str("cert_key", key).Str("id", id).Msg("restored archived certificate")
+	return entry.Certificate, nil
+}
+
+// CertificateKey returns the storage key of the cert of the domain
+func CertificateKey(domain string) (string, error) {
+	request := &CertRequest{Domain: domain}
+	if err := request.Normalize(); err != nil {
+		return "", err
+	}
+	return request.pathCert(), nil
+}
@@ -0,0 +1,61 @@
+package certstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore/libkv/local"
+)
+
+func TestHistory(t *testing.T) {
+	storage, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	key, err := CertificateKey("Example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "certs/example.com.json", key)
+
+	start := time.Now().Add(-time.Hour)
+	for n := 0; n < 4; n++ {
+		assert.NoError(t, archive(storage, key, 2))
+		cert := testCertificateValidity(t, start.Add(time.Duration(n)*time.Minute), time.Now().Add(time.Hour), "example.com")
+		putCertificate(t, storage, key, cert)
+	}
+
+	// the first three certificates have been archived, the oldest is beyond the retention
+	history, err := History(storage, key)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.True(t, history[0].NotBefore.After(history[1].NotBefore))
+		assert.Equal(t, start.Add(2*time.Minute).Unix(), history[0].NotBefore.Unix())
+	}
+
+	restored, err := Restore(storage, key, history[1].ID, 2)
+	assert.NoError(t, err)
+	current, err := storage.Get(key)
+	assert.NoError(t, err)
+	cert, err := decodeRecord(current.Value)
+	assert.NoError(t, err)
+	assert.Equal(t, restored.Certificate, cert.Certificate)
+
+	// the replaced certificate has been archived
+	history, err = History(storage, key)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, start.Add(3*time.Minute).Unix(), history[0].NotBefore.Unix())
+	}
+
+	_, err = Restore(storage, key, "../../user", 2)
+	assert.Error(t, err)
+	_, err = Restore(storage, key, "abc", 2)
+	assert.Equal(t, store.ErrKeyNotFound, err)
+
+	// expired certificates are not restored
+	expired := testCertificateValidity(t, start.Add(-48*time.Hour), start.Add(-24*time.Hour), "example.com")
+	putCertificate(t, storage, key, expired)
+	assert.NoError(t, archive(storage, key, 5))
+	history, _ = History(storage, key)
+	_, err = Restore(storage, key, history[len(history)-1].ID, 5)
+	assert.ErrorContains(t, err, "expired")
+}
@@ -0,0 +1,140 @@
+package certstore
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrImportSkipped is returned if the store already has the same or a longer valid certificate
+var ErrImportSkipped = errors.New("stored certificate is the same or valid longer")
+
+// ParseImport creates a certificate record from pem encoded files.
+// The certificate may contain the chain (fullchain.pem), the private key must belong to the certificate.
+func ParseImport(certPEM, keyPEM, chainPEM []byte) (*CertificateResource, error) {
+	leaf, rest := pem.Decode(certPEM)
+	if leaf == nil || leaf.Type != "CERTIFICATE" {
+		return nil, errors.New("no pem encoded certificate found")
+	}
+	if len(bytes.TrimSpace(chainPEM)) == 0 {
+		chainPEM = rest
+	}
+	cert := &CertificateResource{
+		Version:           RecordVersion,
+		PrivateKey:        keyPEM,
+		Certificate:       pem.EncodeToMemory(leaf),
+		IssuerCertificate: bytes.TrimLeft(chainPEM, "\n"),
+	}
+
+	if _, err := tls.X509KeyPair(cert.Certificate, keyPEM); err != nil {
+		return nil, fmt.Errorf("private key does not match the certificate: %v", err)
+	}
+	info, err := cert.ParseCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if info.NotAfter.Before(time.Now()) {
+		return nil, fmt.Errorf("certificate expired at %s", info.NotAfter.Format(time.RFC3339))
+	}
+
+	names := entryNames(info)
+	if len(names) == 0 {
+		return nil, errors.New("certificate does not contain any domain")
+	}
+	for i, name := range names {
+		if names[i], err = NormalizeDomain(name); err != nil {
+			return nil, err
+		}
+	}
+	cert.Domain = names[0]
+	// the validity is kept in the cert, the metadata records when it has been imported
+	cert.Metadata = &CertificateMetadata{
+		IssuedAt: time.Now().UTC(),
+		Imported: true,
+		Request:  &CertRequest{Domain: names[0], San: removeDuplicates(names)[1:]},
+	}
+	return cert, nil
+}
+
+// ImportFiles reads the certificate, private key and optional chain file
+func ImportFiles(certFile, keyFile, chainFile string) (*CertificateResource, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	var chainPEM []byte
+	if chainFile != "" {
+		if chainPEM, err = os.ReadFile(chainFile); err != nil {
+			return nil, err
+		}
+	}
+	return ParseImport(certPEM, keyPEM, chainPEM)
+}
+
+// CertbotLineages returns the certificate directories of the certbot live directory
+func CertbotLineages(live string) ([]string, error) {
+	entries, err := os.ReadDir(live)
+	if err != nil {
+		return nil, err
+	}
+	lineages := []string{}
+	for _, entry := range entries {
+		dir := filepath.Join(live, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, "cert.pem")); err != nil {
+			// README and other files
+			continue
+		}
+		lineages = append(lineages, dir)
+	}
+	sort.Strings(lineages)
+	return lineages, nil
+}
+
+// ImportCertbotLineage reads the certificate of a certbot live directory
+func ImportCertbotLineage(dir string) (*CertificateResource, error) {
+	return ImportFiles(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "privkey.pem"), filepath.Join(dir, "chain.pem"))
+}
+
+// Import writes the certificate to the store, it is served like an obtained one until it needs to be renewed.
+// A stored certificate of the domain is archived, unless it is the same or valid longer and force is not set.
+func Import(storage store.Store, cert *CertificateResource, force bool, retention int) error {
+	key := (&CertRequest{Domain: cert.Domain}).pathCert()
+	info, err := cert.ParseCertificate()
+	if err != nil {
+		return err
+	}
+
+	pair, err := storage.Get(key)
+	if err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	if pair != nil && !force {
+		if current, err := newHistoryEntry(pair.Value); err == nil && !current.NotAfter.Before(info.NotAfter) {
+			return ErrImportSkipped
+		}
+	}
+
+	if err := archive(storage, key, retention); err != nil {
+		log.Warn().Err(err).Str("domain", cert.Domain).Msg("cannot archive replaced certificate")
+	}
+	value, _ := json.Marshal(cert)
+	if err := storage.Put(key, value, nil); err != nil {
+		return err
+	}
+	log.Info().Str("domain", cert.Domain).Time("not_after", info.NotAfter).Msg("imported certificate")
+	return nil
+}
@@ -0,0 +1,80 @@
+package certstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore/libkv/local"
+)
+
+func writeLineage(t *testing.T, live string, name string, cert *CertificateResource, key []byte) {
+	dir := filepath.Join(live, name)
+	assert.NoError(t, os.MkdirAll(dir, 0700))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "cert.pem"), cert.Certificate, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "chain.pem"), cert.IssuerCertificate, 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "privkey.pem"), key, 0600))
+}
+
+func TestParseImport(t *testing.T) {
+	cert := testCertificate(t, "Example.com", "www.example.com")
+	issuer := testCertificate(t, "Issuer")
+
+	// fullchain
+	imported, err := ParseImport(append(cert.Certificate, issuer.Certificate...), cert.PrivateKey, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", imported.Domain)
+	assert.Equal(t, cert.Certificate, imported.Certificate)
+	assert.Equal(t, issuer.Certificate, imported.IssuerCertificate)
+	assert.True(t, imported.Metadata.Imported)
+	assert.WithinDuration(t, time.Now(), imported.Metadata.IssuedAt, time.Minute)
+	assert.Equal(t, []string{"www.example.com"}, imported.Metadata.Request.San)
+
+	_, err = ParseImport(cert.Certificate, issuer.PrivateKey, nil)
+	assert.ErrorContains(t, err, "does not match")
+
+	expired := testCertificateValidity(t, time.Now().Add(-48*time.Hour), time.Now().Add(-time.Hour), "example.com")
+	_, err = ParseImport(expired.Certificate, expired.PrivateKey, nil)
+	assert.ErrorContains(t, err, "expired")
+
+	_, err = ParseImport(cert.PrivateKey, cert.PrivateKey, nil)
+	assert.Error(t, err)
+}
+
+func TestImportCertbot(t *testing.T) {
+	live := t.TempDir()
+	storage, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+
+	issuer := testCertificate(t, "Issuer")
+	cert := testCertificate(t, "example.com")
+	cert.IssuerCertificate = issuer.Certificate
+	writeLineage(t, live, "example.com", cert, cert.PrivateKey)
+	writeLineage(t, live, "broken.example.com", testCertificate(t, "broken.example.com"), issuer.PrivateKey)
+	assert.NoError(t, os.WriteFile(filepath.Join(live, "README"), []byte("certbot"), 0600))
+
+	lineages, err := CertbotLineages(live)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(live, "broken.example.com"), filepath.Join(live, "example.com")}, lineages)
+
+	_, err = ImportCertbotLineage(lineages[0])
+	assert.Error(t, err)
+	imported, err := ImportCertbotLineage(lineages[1])
+	assert.NoError(t, err)
+	assert.NoError(t, Import(storage, imported, false, 5))
+
+	// served by the index like an obtained certificate
+	i := newIndex()
+	assert.NoError(t, i.load(storage))
+	found := i.find(&CertRequest{Domain: "example.com"}, DefaultSelectionPolicy)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, issuer.Certificate, found.cert.IssuerCertificate)
+		assert.Equal(t, cert.PrivateKey, found.cert.PrivateKey)
+	}
+
+	assert.Equal(t, ErrImportSkipped, Import(storage, imported, false, 5))
+	assert.NoError(t, Import(storage, imported, true, 5))
+}
@@ -0,0 +1,193 @@
+package certstore
+
+import (
+	"bytes"
+	"crypto/x509"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/libkv/store"
+	"github.com/rs/zerolog/log"
+)
+
+// indexEntry is a stored certificate with its parsed x509 certificate
+type indexEntry struct {
+	key   string
+	value []byte
+	cert  *CertificateResource
+	info  *x509.Certificate
+}
+
+// index of the stored certificates by their names, lookups do not need to read and parse the storage
+type index struct {
+	mu sync.RWMutex
+	// entries by storage key
+	entries map[string]*indexEntry
+	// entries by lower case dns name, wildcard names are kept as they are
+	names map[string]map[string]*indexEntry
+}
+
+func newIndex() *index {
+	return &index{
+		entries: map[string]*indexEntry{},
+		names:   map[string]map[string]*indexEntry{},
+	}
+}
+
+// indexKey removes the leading slash some backends return on List
+func indexKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+// entryNames returns all names the certificate is issued for
+func entryNames(info *x509.Certificate) []string {
+	names := []string{}
+	if info.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(info.Subject.CommonName))
+	}
+	for _, name := range info.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+	return removeDuplicates(names)
+}
+
+// put adds or replaces the certificate of the key
+func (i *index) put(key string, value []byte) error {
+	cert, err := decodeRecord(value)
+	if err != nil {
+		return err
+	}
+	info, err := cert.ParseCertificate()
+	if err != nil {
+		return err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.remove(key)
+	entry := &indexEntry{key: key, value: value, cert: cert, info: info}
+	i.entries[key] = entry
+	for _, name := range entryNames(info) {
+		if i.names[name] == nil {
+			i.names[name] = map[string]*indexEntry{}
+		}
+		i.names[name][key] = entry
+	}
+	return nil
+}
+
+// remove the certificate of the key, the caller must hold the lock
+func (i *index) remove(key string) {
+	entry, ok := i.entries[key]
+	if !ok {
+		return
+	}
+	delete(i.entries, key)
+	for _, name := range entryNames(entry.info) {
+		delete(i.names[name], key)
+		if len(i.names[name]) == 0 {
+			delete(i.names, name)
+		}
+	}
+}
+
+// update replaces the index with the listed certificates, unchanged certificates are not parsed again
+func (i *index) update(pairs []*store.KVPair) {
+	listed := map[string]bool{}
+	for _, pair := range pairs {
+		if len(pair.Value) == 0 {
+			// directory entries of etcd and zookeeper
+			continue
+		}
+		key := indexKey(pair.Key)
+		listed[key] = true
+
+		i.mu.RLock()
+		entry, ok := i.entries[key]
+		i.mu.RUnlock()
+		if ok && bytes.Equal(entry.value, pair.Value) {
+			continue
+		}
+		if err := i.put(key, pair.Value); err != nil {
+			log.Err(err).Str("cert_key", pair.Key).Msg("Could not index stored certificate")
+		}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for key := range i.entries {
+		if !listed[key] {
+			i.remove(key)
+		}
+	}
+}
+
+// load reads all certificates of the storage
+func (i *index) load(storage store.Store) error {
+	list, err := storage.List("certs/")
+	if err == store.ErrKeyNotFound {
+		list = nil
+	} else if err != nil {
+		return err
+	}
+	i.update(list)
+	return nil
+}
+
+// candidates returns the certificates issued for the name or a wildcard covering it, ordered by key
+func (i *index) candidates(name string) []*indexEntry {
+	name = strings.ToLower(name)
+	lookup := []string{name}
+	if !strings.HasPrefix(name, "*.") {
+		if _, parent, ok := strings.Cut(name, "."); ok {
+			lookup = append(lookup, "*."+parent)
+		}
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	entries := []*indexEntry{}
+	seen := map[string]bool{}
+	for _, n := range lookup {
+		for key, entry := range i.names[n] {
+			if !seen[key] {
+				seen[key] = true
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].key < entries[b].key })
+	return entries
+}
+
+// find returns the indexed certificate matching the request preferred by the policy
+func (i *index) find(r *CertRequest, policy []SelectionCriterion) *indexEntry {
+	matches := []*indexEntry{}
+	for _, entry := range i.candidates(r.Domain) {
+		if r.matchCertificate(entry.info) {
+			matches = append(matches, entry)
+		}
+	}
+	entry, reason := selectEntry(r, policy, matches)
+	if entry == nil {
+		return nil
+	}
+
+	event := log.Debug().Str("domain", r.Domain).Str("cert_key", entry.key).Str("selected_by", reason)
+	if event.Enabled() {
+		others := []string{}
+		for _, other := range matches[1:] {
+			others = append(others, other.key)
+		}
+		event.Strs("candidates", others).Msg("selected stored certificate")
+	}
+	return entry
+}
+
+// delete the certificate of the key
+func (i *index) delete(key string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.remove(indexKey(key))
+}
@@ -0,0 +1,115 @@
+package certstore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore/libkv/local"
+)
+
+func TestIndex(t *testing.T) {
+	storage, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	putCertificate(t, storage, "certs/example.com.json", testCertificate(t, "example.com", "www.example.com"))
+	putCertificate(t, storage, "certs/*.example.org.json", testCertificate(t, "*.example.org"))
+	assert.NoError(t, storage.Put("certs/broken.json", []byte(`{"domain":"broken"}`), nil))
+
+	i := newIndex()
+	assert.NoError(t, i.load(storage))
+	assert.Len(t, i.entries, 2)
+
+	entry := i.find(&CertRequest{Domain: "www.example.com"}, DefaultSelectionPolicy)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, "example.com", entry.cert.Domain)
+	}
+	entry = i.find(&CertRequest{Domain: "a.example.org", San: []string{"b.example.org"}}, DefaultSelectionPolicy)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, "*.example.org", entry.cert.Domain)
+	}
+	assert.Nil(t, i.find(&CertRequest{Domain: "a.b.example.org"}, DefaultSelectionPolicy))
+	assert.Nil(t, i.find(&CertRequest{Domain: "example.com", San: []string{"mail.example.com"}}, DefaultSelectionPolicy))
+	assert.Nil(t, i.find(&CertRequest{Domain: "example.com", ValidDays: 30}, DefaultSelectionPolicy))
+
+	// removed and replaced certificates
+	assert.NoError(t, storage.Delete("certs/*.example.org.json"))
+	putCertificate(t, storage, "certs/example.com.json", testCertificate(t, "example.com", "mail.example.com"))
+	assert.NoError(t, i.load(storage))
+	assert.Nil(t, i.find(&CertRequest{Domain: "a.example.org"}, DefaultSelectionPolicy))
+	assert.Nil(t, i.find(&CertRequest{Domain: "www.example.com"}, DefaultSelectionPolicy))
+	assert.NotNil(t, i.find(&CertRequest{Domain: "mail.example.com"}, DefaultSelectionPolicy))
+	assert.Empty(t, i.names["*.example.org"])
+}
+
+func TestFindStoredCertReloadsIndex(t *testing.T) {
+	storage, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	c := &CertStore{storage: storage, index: newIndex()}
+	assert.NoError(t, c.index.load(storage))
+
+	// written by another instance
+	putCertificate(t, storage, "certs/example.com.json", testCertificate(t, "example.com"))
+	cert, err := c.findStoredCert(&CertRequest{Domain: "example.com"})
+	assert.NoError(t, err)
+	assert.NotNil(t, cert)
+}
+
+func TestFindStoredCertReloadLimit(t *testing.T) {
+	storage, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	c := &CertStore{storage: storage, index: newIndex(), indexLoaded: time.Now()}
+	putCertificate(t, storage, "certs/example.com.json", testCertificate(t, "example.com"))
+
+	// reloaded recently
+	cert, err := c.findStoredCert(&CertRequest{Domain: "example.com"})
+	assert.NoError(t, err)
+	assert.Nil(t, cert)
+
+	// updated by the watch
+	c.indexLoaded = time.Time{}
+	c.watched.Store(true)
+	cert, err = c.findStoredCert(&CertRequest{Domain: "example.com"})
+	assert.NoError(t, err)
+	assert.Nil(t, cert)
+
+	c.watched.Store(false)
+	cert, err = c.findStoredCert(&CertRequest{Domain: "example.com"})
+	assert.NoError(t, err)
+	assert.NotNil(t, cert)
+}
+
+// benchmarkStorage creates a storage with 5000 certificates
+func benchmarkStorage(b *testing.B) store.Store {
+	storage, _ := local.New(nil, &store.Config{Bucket: b.TempDir()})
+	for n := 0; n < 5000; n++ {
+		domain := fmt.Sprintf("host%d.example.com", n)
+		putCertificate(b, storage, "certs/"+domain+".json", testCertificate(b, domain))
+	}
+	return storage
+}
+
+// BenchmarkIndexLoad lists and parses all certificates, every lookup did this before the index
+func BenchmarkIndexLoad(b *testing.B) {
+	storage := benchmarkStorage(b)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if err := newIndex().load(storage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIndexFind(b *testing.B) {
+	storage := benchmarkStorage(b)
+	i := newIndex()
+	if err := i.load(storage); err != nil {
+		b.Fatal(err)
+	}
+	request := &CertRequest{Domain: "host4999.example.com"}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if i.find(request, DefaultSelectionPolicy) == nil {
+			b.Fatal("certificate not found")
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+const BOLT = "bolt"
+
+// indexSize is the length of the index stored in front of every value
+const indexSize = 8
+
+// ErrInvalidValue is returned for values without index, e.g. written by another tool
+var ErrInvalidValue = errors.New("bolt value has no index")
+
+// Bolt "libkv" store using a single bbolt database file.
+// Keys are kept sorted in a B+tree, listing a directory is a range scan over its prefix.
+// Every write gets a new index from the sequence of the bucket, it is used for AtomicPut and AtomicDelete.
+// The file is locked exclusively, other processes (e.g. the storage commands while the server is running)
+// wait for the connection timeout and fail.
+type Bolt struct {
+	db     *bolt.DB
+	bucket []byte
+}
+
+// Register registers bolt to libkv
+func Register() {
+	libkv.AddStore(BOLT, New)
+}
+
+// New opens or creates the database file of the first address, the bucket defaults to certjunkie
+func New(addrs []string, options *store.Config) (store.Store, error) {
+	if len(addrs) == 0 || addrs[0] == "" {
+		return nil, errors.New("bolt database path is not set")
+	}
+	if options == nil {
+		options = &store.Config{}
+	}
+	bucket := options.Bucket
+	if bucket == "" {
+		bucket = "certjunkie"
+	}
+	timeout := options.ConnectionTimeout
+	if timeout == 0 {
+		// do not block forever if another process holds the file lock
+		timeout = 5 * time.Second
+	}
+
+	if err := os.MkdirAll(filepath.Dir(addrs[0]), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(addrs[0], 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	b := &Bolt{db: db, bucket: []byte(bucket)}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(b.bucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+func normalize(key string) string {
+	return strings.Trim(key, "/")
+}
+
+// index returns the index of the stored value
+func index(key []byte, data []byte) (uint64, error) {
+	if len(data) < indexSize {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidValue, key)
+	}
+	return binary.BigEndian.Uint64(data[:indexSize]), nil
+}
+
+func decode(key []byte, data []byte) (*store.KVPair, error) {
+	lastIndex, err := index(key, data)
+	if err != nil {
+		return nil, err
+	}
+	value := make([]byte, len(data)-indexSize)
+	copy(value, data[indexSize:])
+	return &store.KVPair{
+		Key:       string(key),
+		Value:     value,
+		LastIndex: lastIndex,
+	}, nil
+}
+
+// put writes the value with a new index
+func (b *Bolt) put(bucket *bolt.Bucket, key string, value []byte) (*store.KVPair, error) {
+	index, err := bucket.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, indexSize+len(value))
+	binary.BigEndian.PutUint64(data, index)
+	copy(data[indexSize:], value)
+	if err := bucket.Put([]byte(key), data); err != nil {
+		return nil, err
+	}
+	return &store.KVPair{Key: key, Value: value, LastIndex: index}, nil
+}
+
+// Put writes the value
+func (b *Bolt) Put(key string, value []byte, opts *store.WriteOptions) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		_, err := b.put(tx.Bucket(b.bucket), normalize(key), value)
+		return err
+	})
+}
+
+// Get the value
+func (b *Bolt) Get(key string) (*store.KVPair, error) {
+	var pair *store.KVPair
+	err := b.db.View(func(tx *bolt.Tx) error {
+		k := []byte(normalize(key))
+		data := tx.Bucket(b.bucket).Get(k)
+		if data == nil {
+			return store.ErrKeyNotFound
+		}
+		var err error
+		pair, err = decode(k, data)
+		return err
+	})
+	return pair, err
+}
+
+// Delete the key
+func (b *Bolt) Delete(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.bucket)
+		k := []byte(normalize(key))
+		if bucket.Get(k) == nil {
+			return store.ErrKeyNotFound
+		}
+		return bucket.Delete(k)
+	})
+}
+
+// Exists checks if the key exists
+func (b *Bolt) Exists(key string) (bool, error) {
+	_, err := b.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// scan calls fn for every key below the directory
+func scan(bucket *bolt.Bucket, directory string, fn func(k, v []byte)) {
+	prefix := []byte(normalize(directory) + "/")
+	if len(prefix) == 1 {
+		prefix = nil
+	}
+	c := bucket.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		fn(k, v)
+	}
+}
+
+// List all values below the directory
+func (b *Bolt) List(directory string) ([]*store.KVPair, error) {
+	pairs := []*store.KVPair{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		scan(tx.Bucket(b.bucket), directory, func(k, v []byte) {
+			pair, decodeErr := decode(k, v)
+			if decodeErr != nil {
+				err = decodeErr
+				return
+			}
+			pairs = append(pairs, pair)
+		})
+		if err != nil {
+			return err
+		}
+		if len(pairs) == 0 {
+			return store.ErrKeyNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// DeleteTree removes all keys below the directory
+func (b *Bolt) DeleteTree(directory string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.bucket)
+		keys := [][]byte{}
+		scan(bucket, directory, func(k, v []byte) {
+			keys = append(keys, append([]byte{}, k...))
+		})
+		for _, k := range keys {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AtomicPut writes the value if the index has not been changed, previous nil only creates a new key
+func (b *Bolt) AtomicPut(key string, value []byte, previous *store.KVPair, opts *store.WriteOptions) (bool, *store.KVPair, error) {
+	var pair *store.KVPair
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.bucket)
+		k := normalize(key)
+		current := bucket.Get([]byte(k))
+		switch {
+		case previous == nil && current != nil:
+			return store.ErrKeyExists
+		case previous != nil && current == nil:
+			return store.ErrKeyNotFound
+		case previous != nil:
+			currentIndex, err := index([]byte(k), current)
+			if err != nil {
+				return err
+			}
+			if currentIndex != previous.LastIndex {
+				return store.ErrKeyModified
+			}
+		}
+		var err error
+		pair, err = b.put(bucket, k, value)
+		return err
+	})
+	if err != nil {
+		return false, nil, err
+	}
+	return true, pair, nil
+}
+
+// AtomicDelete removes the key if the index has not been changed
+func (b *Bolt) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(b.bucket)
+		k := []byte(normalize(key))
+		current := bucket.Get(k)
+		if current == nil {
+			return store.ErrKeyNotFound
+		}
+		currentIndex, err := index(k, current)
+		if err != nil {
+			return err
+		}
+		if currentIndex != previous.LastIndex {
+			return store.ErrKeyModified
+		}
+		return bucket.Delete(k)
+	})
+	return err == nil, err
+}
+
+// NewLock is not implemented
+func (b *Bolt) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Watch is not implemented
+func (b *Bolt) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// WatchTree is not implemented
+func (b *Bolt) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Close the database
+func (b *Bolt) Close() {
+	b.db.Close()
+}
@@ -0,0 +1,83 @@
+package bolt
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/testutils"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func makeStore(t testing.TB) store.Store {
+	kv, err := New([]string{filepath.Join(t.TempDir(), "certjunkie.db")}, nil)
+	if err != nil {
+		t.Fatalf("cannot create store: %v", err)
+	}
+	t.Cleanup(kv.Close)
+	return kv
+}
+
+func TestRegister(t *testing.T) {
+	Register()
+
+	kv, err := libkv.NewStore(BOLT, []string{filepath.Join(t.TempDir(), "certjunkie.db")}, nil)
+	assert.NoError(t, err)
+	if _, ok := kv.(*Bolt); !ok {
+		t.Fatal("Error registering and initializing bolt")
+	}
+	kv.Close()
+}
+
+func TestBoltStore(t *testing.T) {
+	kv := makeStore(t)
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunCleanup(t, kv)
+}
+
+func TestBoltList(t *testing.T) {
+	kv := makeStore(t)
+	assert.NoError(t, kv.Put("user.json", []byte("user"), nil))
+	assert.NoError(t, kv.Put("certs/a.json", []byte("a"), nil))
+	assert.NoError(t, kv.Put("/certs/b.json", []byte("b"), nil))
+	assert.NoError(t, kv.Put("certsbackup/c.json", []byte("c"), nil))
+
+	pairs, err := kv.List("certs/")
+	assert.NoError(t, err)
+	if assert.Len(t, pairs, 2) {
+		assert.Equal(t, "certs/a.json", pairs[0].Key)
+		assert.Equal(t, "certs/b.json", pairs[1].Key)
+	}
+}
+
+func TestBoltInvalidValue(t *testing.T) {
+	kv := makeStore(t)
+	b := kv.(*Bolt)
+	assert.NoError(t, b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.bucket).Put([]byte("certs/short.json"), []byte("abc"))
+	}))
+
+	_, err := kv.Get("certs/short.json")
+	assert.ErrorIs(t, err, ErrInvalidValue)
+	_, err = kv.List("certs/")
+	assert.ErrorIs(t, err, ErrInvalidValue)
+	_, err = kv.AtomicDelete("certs/short.json", &store.KVPair{Key: "certs/short.json"})
+	assert.ErrorIs(t, err, ErrInvalidValue)
+}
+
+func BenchmarkBoltList(b *testing.B) {
+	kv := makeStore(b)
+	for i := 0; i < 5000; i++ {
+		kv.Put(fmt.Sprintf("certs/%d.example.com.json", i), []byte(`{"domain":"example.com"}`), nil)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := kv.List("certs/"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	KUBERNETES = "kubernetes"
+
+	// serviceAccountNamespace contains the namespace of the pod when running in cluster
+	serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	// SecretType is the type of the secrets created by the store
+	SecretType corev1.SecretType = "certjunkie.project0.de/storage"
+
+	labelManagedBy = "app.kubernetes.io/managed-by"
+	labelComponent = "app.kubernetes.io/component"
+	// labelDirectory is followed by the depth, the value is the hash of the directory of this depth
+	labelDirectory    = "certjunkie.project0.de/dir-"
+	annotationKey     = "certjunkie.project0.de/key"
+	annotationVersion = "certjunkie.project0.de/version"
+	dataValue         = "value"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Kubernetes "libkv" store saving every key as secret in a namespace.
+// The secrets are labeled with all parent directories of the key to list a directory with a selector,
+// the original key is kept in an annotation.
+// The index of a key is a version annotation, updates are guarded by the resource version of the secret.
+type Kubernetes struct {
+	client    k8s.Interface
+	namespace string
+	timeout   time.Duration
+}
+
+// Register registers kubernetes to libkv
+func Register() {
+	libkv.AddStore(KUBERNETES, New)
+}
+
+// New creates a kubernetes store, the first address is the path of a kubeconfig, otherwise the in cluster config is used.
+// The bucket is the namespace, it defaults to the namespace of the pod.
+func New(addrs []string, options *store.Config) (store.Store, error) {
+	if options == nil {
+		options = &store.Config{}
+	}
+
+	var config *rest.Config
+	var err error
+	if len(addrs) > 0 && addrs[0] != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", addrs[0])
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := k8s.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := options.Bucket
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("cannot detect namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	s := NewWithClient(client, namespace)
+	s.timeout = options.ConnectionTimeout
+	return s, nil
+}
+
+// NewWithClient creates a kubernetes store with an existing client
+func NewWithClient(client k8s.Interface, namespace string) *Kubernetes {
+	return &Kubernetes{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+func normalize(key string) string {
+	return strings.Trim(key, "/")
+}
+
+// secretName converts the key into a valid secret name, the hash keeps it unique
+func secretName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(key), "-"), "-")
+	if len(name) > 200 {
+		name = strings.TrimRight(name[:200], "-")
+	}
+	if name == "" {
+		return hex.EncodeToString(sum[:8])
+	}
+	return name + "-" + hex.EncodeToString(sum[:5])
+}
+
+func (k *Kubernetes) context() (context.Context, context.CancelFunc) {
+	if k.timeout > 0 {
+		return context.WithTimeout(context.Background(), k.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// directoryLabel returns the label of the directory, it contains a hash as keys may exceed the length of label values
+func directoryLabel(directory string) (string, string) {
+	sum := sha256.Sum256([]byte(directory))
+	return labelDirectory + strconv.Itoa(strings.Count(directory, "/")+1), hex.EncodeToString(sum[:16])
+}
+
+// keyLabels returns the labels of the secret of the key
+func keyLabels(key string) map[string]string {
+	set := map[string]string{
+		labelManagedBy: "certjunkie",
+		labelComponent: "storage",
+	}
+	parts := strings.Split(key, "/")
+	for depth := 1; depth < len(parts); depth++ {
+		name, value := directoryLabel(strings.Join(parts[:depth], "/"))
+		set[name] = value
+	}
+	return set
+}
+
+// selector selects the secrets below the directory, all secrets of the store if it is empty
+func (k *Kubernetes) selector(directory string) string {
+	set := labels.Set{labelManagedBy: "certjunkie", labelComponent: "storage"}
+	if directory != "" {
+		name, value := directoryLabel(directory)
+		set[name] = value
+	}
+	return labels.SelectorFromSet(set).String()
+}
+
+func version(secret *corev1.Secret) uint64 {
+	v, _ := strconv.ParseUint(secret.Annotations[annotationVersion], 10, 64)
+	return v
+}
+
+func pair(secret *corev1.Secret) *store.KVPair {
+	value := secret.Data[dataValue]
+	if value == nil {
+		value = []byte{}
+	}
+	return &store.KVPair{Key: secret.Annotations[annotationKey], Value: value, LastIndex: version(secret)}
+}
+
+// get returns the secret of the key
+func (k *Kubernetes) get(key string) (*corev1.Secret, error) {
+	ctx, cancel := k.context()
+	defer cancel()
+	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(ctx, secretName(key), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, store.ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if secret.Annotations[annotationKey] != key {
+		return nil, fmt.Errorf("secret %s does not belong to key %s", secret.Name, key)
+	}
+	return secret, nil
+}
+
+// create a new secret for the key
+func (k *Kubernetes) create(key string, value []byte) (*corev1.Secret, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName(key),
+			Namespace: k.namespace,
+			Labels:    keyLabels(key),
+			Annotations: map[string]string{
+				annotationKey:     key,
+				annotationVersion: "1",
+			},
+		},
+		Type: SecretType,
+		Data: map[string][]byte{dataValue: value},
+	}
+	ctx, cancel := k.context()
+	defer cancel()
+	secret, err := k.client.CoreV1().Secrets(k.namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil, store.ErrKeyExists
+	}
+	return secret, err
+}
+
+// update the existing secret, it fails if the secret has been changed since it has been read
+func (k *Kubernetes) update(secret *corev1.Secret, value []byte) (*corev1.Secret, error) {
+	secret = secret.DeepCopy()
+	secret.Data = map[string][]byte{dataValue: value}
+	secret.Labels = keyLabels(secret.Annotations[annotationKey])
+	secret.Annotations[annotationVersion] = strconv.FormatUint(version(secret)+1, 10)
+
+	ctx, cancel := k.context()
+	defer cancel()
+	// the resource version of the read secret is a precondition of the update
+	secret, err := k.client.CoreV1().Secrets(k.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return nil, store.ErrKeyModified
+	}
+	return secret, err
+}
+
+// Get the value
+func (k *Kubernetes) Get(key string) (*store.KVPair, error) {
+	secret, err := k.get(normalize(key))
+	if err != nil {
+		return nil, err
+	}
+	return pair(secret), nil
+}
+
+// Put creates or updates the secret
+func (k *Kubernetes) Put(key string, value []byte, opts *store.WriteOptions) error {
+	key = normalize(key)
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		var secret *corev1.Secret
+		secret, err = k.get(key)
+		if err == store.ErrKeyNotFound {
+			_, err = k.create(key, value)
+		} else if err == nil {
+			_, err = k.update(secret, value)
+		}
+		// concurrent writes, try again with the current secret
+		if err != store.ErrKeyExists && err != store.ErrKeyModified {
+			return err
+		}
+	}
+	return err
+}
+
+// Delete the secret
+func (k *Kubernetes) Delete(key string) error {
+	secret, err := k.get(normalize(key))
+	if err != nil {
+		return err
+	}
+	return k.delete(secret, nil)
+}
+
+func (k *Kubernetes) delete(secret *corev1.Secret, preconditions *metav1.Preconditions) error {
+	ctx, cancel := k.context()
+	defer cancel()
+	err := k.client.CoreV1().Secrets(k.namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{Preconditions: preconditions})
+	if apierrors.IsNotFound(err) {
+		return store.ErrKeyNotFound
+	}
+	if apierrors.IsConflict(err) {
+		return store.ErrKeyModified
+	}
+	return err
+}
+
+// Exists checks if the secret exists
+func (k *Kubernetes) Exists(key string) (bool, error) {
+	_, err := k.get(normalize(key))
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// secrets lists the secrets below the directory by label selector
+func (k *Kubernetes) secrets(directory string) ([]corev1.Secret, error) {
+	directory = normalize(directory)
+	ctx, cancel := k.context()
+	defer cancel()
+	list, err := k.client.CoreV1().Secrets(k.namespace).List(ctx, metav1.ListOptions{LabelSelector: k.selector(directory)})
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := directory + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+	secrets := []corev1.Secret{}
+	for _, secret := range list.Items {
+		if strings.HasPrefix(secret.Annotations[annotationKey], prefix) {
+			secrets = append(secrets, secret)
+		}
+	}
+	if len(secrets) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return secrets, nil
+}
+
+// List all values below the directory
+func (k *Kubernetes) List(directory string) ([]*store.KVPair, error) {
+	secrets, err := k.secrets(directory)
+	if err != nil {
+		return nil, err
+	}
+	pairs := []*store.KVPair{}
+	for i := range secrets {
+		pairs = append(pairs, pair(&secrets[i]))
+	}
+	return pairs, nil
+}
+
+// DeleteTree removes all secrets below the directory
+func (k *Kubernetes) DeleteTree(directory string) error {
+	secrets, err := k.secrets(directory)
+	if err != nil {
+		return err
+	}
+	for i := range secrets {
+		if err := k.delete(&secrets[i], nil); err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// AtomicPut writes the value if the version has not been changed, previous nil only creates a new secret
+func (k *Kubernetes) AtomicPut(key string, value []byte, previous *store.KVPair, opts *store.WriteOptions) (bool, *store.KVPair, error) {
+	key = normalize(key)
+	var secret *corev1.Secret
+	var err error
+
+	if previous == nil {
+		secret, err = k.create(key, value)
+	} else {
+		secret, err = k.get(key)
+		if err != nil {
+			return false, nil, err
+		}
+		if version(secret) != previous.LastIndex {
+			return false, nil, store.ErrKeyModified
+		}
+		secret, err = k.update(secret, value)
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return true, pair(secret), nil
+}
+
+// AtomicDelete removes the secret if the version has not been changed
+func (k *Kubernetes) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	secret, err := k.get(normalize(key))
+	if err != nil {
+		return false, err
+	}
+	if version(secret) != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	if err := k.delete(secret, &metav1.Preconditions{ResourceVersion: &secret.ResourceVersion}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewLock is not implemented
+func (k *Kubernetes) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Watch is not implemented
+func (k *Kubernetes) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// WatchTree is not implemented
+func (k *Kubernetes) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Close is not required but needs to be implemented
+func (k *Kubernetes) Close() {
+}
@@ -0,0 +1,69 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/libkv/testutils"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetesStore(t *testing.T) {
+	kv := NewWithClient(fake.NewClientset(), "certjunkie")
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunCleanup(t, kv)
+}
+
+func TestKubernetesSecrets(t *testing.T) {
+	client := fake.NewClientset()
+	kv := NewWithClient(client, "certjunkie")
+
+	assert.NoError(t, kv.Put("certjunkie/certs/*.example.com.json", []byte(`{"domain":"*.example.com"}`), nil))
+	assert.NoError(t, kv.Put("certjunkie/user.json", []byte(`{}`), nil))
+
+	secret, err := client.CoreV1().Secrets("certjunkie").Get(context.Background(), secretName("certjunkie/certs/*.example.com.json"), metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Regexp(t, `^certjunkie-certs-example-com-json-[0-9a-f]{10}$`, secret.Name)
+	assert.Equal(t, SecretType, secret.Type)
+	assert.Equal(t, "certjunkie/certs/*.example.com.json", secret.Annotations[annotationKey])
+
+	pairs, err := kv.List("certjunkie/certs/")
+	assert.NoError(t, err)
+	if assert.Len(t, pairs, 1) {
+		assert.Equal(t, "certjunkie/certs/*.example.com.json", pairs[0].Key)
+	}
+
+	// secrets of other applications are ignored
+	secret.Name = "foreign"
+	secret.Labels = nil
+	_, err = client.CoreV1().Secrets("certjunkie").Create(context.Background(), secret, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	pairs, err = kv.List("certjunkie/")
+	assert.NoError(t, err)
+	assert.Len(t, pairs, 2)
+}
+
+func TestKubernetesListSelector(t *testing.T) {
+	client := fake.NewClientset()
+	kv := NewWithClient(client, "certjunkie")
+	assert.NoError(t, kv.Put("certjunkie/certs/example.com.json", []byte(`{}`), nil))
+	assert.NoError(t, kv.Put("certjunkie/history/certs/example.com/1.json", []byte(`{}`), nil))
+
+	pairs, err := kv.List("certjunkie/certs/")
+	assert.NoError(t, err)
+	if assert.Len(t, pairs, 1) {
+		assert.Equal(t, "certjunkie/certs/example.com.json", pairs[0].Key)
+	}
+	pairs, err = kv.List("certjunkie/history/")
+	assert.NoError(t, err)
+	assert.Len(t, pairs, 1)
+
+	// the directory is selected by the api server
+	name, value := directoryLabel("certjunkie/certs")
+	list := client.Actions()[len(client.Actions())-2].(k8stesting.ListAction)
+	assert.Contains(t, list.GetListRestrictions().Labels.String(), name+"="+value)
+}
@@ -1 +1,161 @@
 package libkv
+
+import (
+	"strings"
+
+	"github.com/docker/libkv/store"
+)
+
+// Prefix stores all keys below the prefix and unifies the key semantics of the libkv backends:
+// keys are returned relative to the prefix without leading slash,
+// List returns full keys and skips directory entries (etcd, zookeeper).
+type Prefix struct {
+	store.Store
+	prefix string
+}
+
+// NewPrefix wraps the store, an empty prefix only normalizes the keys
+func NewPrefix(s store.Store, prefix string) *Prefix {
+	return &Prefix{
+		Store:  s,
+		prefix: strings.Trim(prefix, "/"),
+	}
+}
+
+// key returns the key in the backend
+func (p *Prefix) key(key string) string {
+	return strings.TrimPrefix(p.prefix+"/"+strings.TrimLeft(key, "/"), "/")
+}
+
+// relative returns the key relative to the prefix, directory is the listed directory
+func (p *Prefix) relative(key, directory string) string {
+	key = strings.Trim(key, "/")
+	if p.prefix != "" && strings.HasPrefix(key, p.prefix+"/") {
+		return strings.TrimPrefix(key, p.prefix+"/")
+	}
+	directory = strings.Trim(directory, "/")
+	if directory != "" && !strings.Contains(key, "/") {
+		// zookeeper returns the name of the child only
+		return directory + "/" + key
+	}
+	return key
+}
+
+// listed converts the pair of a listed directory
+func (p *Prefix) listed(pair *store.KVPair, directory string) *store.KVPair {
+	return &store.KVPair{Key: p.relative(pair.Key, directory), Value: pair.Value, LastIndex: pair.LastIndex}
+}
+
+// single converts the pair of a requested key
+func single(pair *store.KVPair, key string) *store.KVPair {
+	if pair == nil {
+		return nil
+	}
+	return &store.KVPair{Key: strings.Trim(key, "/"), Value: pair.Value, LastIndex: pair.LastIndex}
+}
+
+// Put the value below the prefix
+func (p *Prefix) Put(key string, value []byte, options *store.WriteOptions) error {
+	return p.Store.Put(p.key(key), value, options)
+}
+
+// Get the value below the prefix
+func (p *Prefix) Get(key string) (*store.KVPair, error) {
+	pair, err := p.Store.Get(p.key(key))
+	if err != nil {
+		return nil, err
+	}
+	return single(pair, key), nil
+}
+
+// Delete the key below the prefix
+func (p *Prefix) Delete(key string) error {
+	return p.Store.Delete(p.key(key))
+}
+
+// Exists checks the key below the prefix
+func (p *Prefix) Exists(key string) (bool, error) {
+	return p.Store.Exists(p.key(key))
+}
+
+// List the values of the directory, directory entries are skipped
+func (p *Prefix) List(directory string) ([]*store.KVPair, error) {
+	pairs, err := p.Store.List(p.key(directory))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*store.KVPair{}
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") || len(pair.Value) == 0 {
+			continue
+		}
+		result = append(result, p.listed(pair, directory))
+	}
+	return result, nil
+}
+
+// DeleteTree removes the directory below the prefix
+func (p *Prefix) DeleteTree(directory string) error {
+	return p.Store.DeleteTree(p.key(directory))
+}
+
+// Watch the key below the prefix
+func (p *Prefix) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	events, err := p.Store.Watch(p.key(key), stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		for pair := range events {
+			select {
+			case out <- single(pair, key):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// WatchTree watches the directory below the prefix
+func (p *Prefix) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	events, err := p.Store.WatchTree(p.key(directory), stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		for pairs := range events {
+			result := make([]*store.KVPair, 0, len(pairs))
+			for _, pair := range pairs {
+				result = append(result, p.listed(pair, directory))
+			}
+			select {
+			case out <- result:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// NewLock creates a lock below the prefix
+func (p *Prefix) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return p.Store.NewLock(p.key(key), options)
+}
+
+// AtomicPut the value below the prefix
+func (p *Prefix) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	ok, pair, err := p.Store.AtomicPut(p.key(key), value, previous, options)
+	return ok, single(pair, key), err
+}
+
+// AtomicDelete the key below the prefix
+func (p *Prefix) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return p.Store.AtomicDelete(p.key(key), previous)
+}
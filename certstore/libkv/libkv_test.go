@@ -0,0 +1,46 @@
+package libkv
+
+import (
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/testutils"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore/libkv/local"
+)
+
+func TestPrefixStore(t *testing.T) {
+	backend, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	kv := NewPrefix(backend, "/certjunkie/")
+	testutils.RunTestCommon(t, kv)
+	testutils.RunCleanup(t, kv)
+
+	assert.NoError(t, kv.Put("/certs/example.com.json", []byte("{}"), nil))
+	exists, err := backend.Exists("certjunkie/certs/example.com.json")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	pair, err := kv.Get("certs/example.com.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "certs/example.com.json", pair.Key)
+
+	// local returns keys with leading slash
+	pairs, err := kv.List("certs/")
+	assert.NoError(t, err)
+	if assert.Len(t, pairs, 1) {
+		assert.Equal(t, "certs/example.com.json", pairs[0].Key)
+	}
+}
+
+func TestPrefixRelative(t *testing.T) {
+	kv := NewPrefix(nil, "certjunkie")
+	// etcd
+	assert.Equal(t, "certs/example.com.json", kv.relative("/certjunkie/certs/example.com.json", "certs/"))
+	// consul
+	assert.Equal(t, "certs/example.com.json", kv.relative("certjunkie/certs/example.com.json", "certs/"))
+	// zookeeper
+	assert.Equal(t, "certs/example.com.json", kv.relative("example.com.json", "certs/"))
+
+	assert.Equal(t, "certs/example.com.json", NewPrefix(nil, "").relative("/certs/example.com.json", "certs"))
+}
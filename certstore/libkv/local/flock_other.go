@@ -0,0 +1,10 @@
+//go:build !unix
+
+package local
+
+import "os"
+
+// flock is not supported, only the writes of this process are serialized
+func flock(f *os.File) error {
+	return nil
+}
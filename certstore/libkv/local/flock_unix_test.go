@@ -0,0 +1,49 @@
+//go:build unix
+
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalFileLock(t *testing.T) {
+	dir := t.TempDir()
+	kv := makeStore(t, dir)
+	assert.NoError(t, kv.Put("certs/example.com.json", []byte("{}"), nil))
+	pair, err := kv.Get("certs/example.com.json")
+	assert.NoError(t, err)
+
+	// another process holds the lock of the bucket
+	f, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_RDWR, 0600)
+	assert.NoError(t, err)
+	assert.NoError(t, flock(f))
+
+	done := make(chan error)
+	go func() {
+		_, _, err := kv.AtomicPut("certs/example.com.json", []byte(`{"a":1}`), pair, nil)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("write has not waited for the file lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assert.NoError(t, f.Close())
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("write has not continued after the file lock has been released")
+	}
+
+	// the lock file is not listed
+	pairs, err := kv.List("")
+	assert.NoError(t, err)
+	assert.Len(t, pairs, 1)
+}
@@ -1,17 +1,33 @@
 package local
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/libkv"
 	"github.com/docker/libkv/store"
+	"github.com/fsnotify/fsnotify"
 )
 
 const LOCAL = "local"
 
+// ErrInvalidKey is returned for keys outside of the storage directory
+var ErrInvalidKey = errors.New("key is outside of the storage directory")
+
+// lock serializes the writes of all local stores in this process
+var lock sync.RWMutex
+
+// lockFile is locked by the writes of all processes using the bucket
+const lockFile = ".lock"
+
 // Local "libkv" store.
+// Files are replaced atomically by renaming a temporary file, the version of every key is kept in a hidden sidecar file.
+// Writes hold a file lock of the bucket, multiple processes can share the directory.
 type Local struct {
 	// Endpoints passed to InitializeMock
 	Endpoints []string
@@ -32,8 +48,14 @@ func New(addrs []string, options *store.Config) (store.Store, error) {
 	}, nil
 }
 
-func (l *Local) absolutePath(relativePath string) string {
-	return filepath.Clean(filepath.Join(l.Options.Bucket, relativePath))
+// absolutePath returns the path of the key, keys resolving outside of the bucket are refused
+func (l *Local) absolutePath(relativePath string) (string, error) {
+	path := filepath.Clean(filepath.Join(l.Options.Bucket, relativePath))
+	rel, err := filepath.Rel(filepath.Clean(l.Options.Bucket), path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidKey
+	}
+	return path, nil
 }
 
 func (l *Local) checkPath(relativePath string) error {
@@ -44,25 +66,129 @@ func (l *Local) checkPath(relativePath string) error {
 	return nil
 }
 
+// hidden files are not listed, they contain the versions and temporary files
+func hidden(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// lockBucket takes the lock of this process and the file lock of the bucket,
+// the version check and the write are not interleaved with other processes. The returned function releases both.
+func (l *Local) lockBucket() (func(), error) {
+	lock.Lock()
+	if err := l.checkPath(l.Options.Bucket); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(l.Options.Bucket, lockFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	if err := flock(f); err != nil {
+		f.Close()
+		lock.Unlock()
+		return nil, err
+	}
+	return func() {
+		f.Close()
+		lock.Unlock()
+	}, nil
+}
+
+// versionPath returns the sidecar file containing the version of the file
+func versionPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".version")
+}
+
+// version returns the version of the file, files written without version have 0
+func version(path string) (uint64, error) {
+	data, err := os.ReadFile(versionPath(path))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// writeFile replaces the file atomically
+func writeFile(path string, value []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// put writes the value and a new version, the caller must hold the lock
+func (l *Local) put(key string, value []byte, current uint64) (*store.KVPair, error) {
+	path, err := l.absolutePath(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.checkPath(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	// the time keeps versions unique if a key is deleted and created again
+	next := uint64(time.Now().UnixNano())
+	if next <= current {
+		next = current + 1
+	}
+	// the value is written first, a reader never gets a new version with an old value
+	if err := writeFile(path, value); err != nil {
+		return nil, err
+	}
+	if err := writeFile(versionPath(path), []byte(strconv.FormatUint(next, 10))); err != nil {
+		return nil, err
+	}
+	return &store.KVPair{Key: key, Value: value, LastIndex: next}, nil
+}
+
 // Put value to file
 func (l *Local) Put(key string, value []byte, opts *store.WriteOptions) error {
-	path := l.absolutePath(key)
+	path, err := l.absolutePath(key)
+	if err != nil {
+		return err
+	}
 	// just create the dir
 	if opts != nil && opts.IsDir {
 		return l.checkPath(path)
 	}
 
-	// create dir first
-	if err := l.checkPath(filepath.Dir(path)); err != nil {
+	unlock, err := l.lockBucket()
+	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, value, 0600)
-
+	defer unlock()
+	current, err := version(path)
+	if err != nil {
+		return err
+	}
+	_, err = l.put(key, value, current)
+	return err
 }
 
 // Get file content
 func (l *Local) Get(key string) (*store.KVPair, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	return l.get(key)
+}
 
+func (l *Local) get(key string) (*store.KVPair, error) {
 	// If pair is nil then the key does not exist
 	exists, err := l.Exists(key)
 	if err != nil {
@@ -71,23 +197,58 @@ func (l *Local) Get(key string) (*store.KVPair, error) {
 	if !exists {
 		return nil, store.ErrKeyNotFound
 	}
-	path := l.absolutePath(key)
+	path, err := l.absolutePath(key)
+	if err != nil {
+		return nil, err
+	}
+	index, err := version(path)
+	if err != nil {
+		return nil, err
+	}
 	value, err := os.ReadFile(path)
 
-	return &store.KVPair{Key: key, Value: value, LastIndex: 0}, err
+	return &store.KVPair{Key: key, Value: value, LastIndex: index}, err
+}
+
+// remove the file and its version
+func (l *Local) remove(key string) error {
+	path, err := l.absolutePath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := os.Remove(versionPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 // Delete file
 func (l *Local) Delete(key string) error {
-	if ok, _ := l.Exists(key); !ok {
+	unlock, err := l.lockBucket()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ok, err := l.Exists(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
 		return store.ErrKeyNotFound
 	}
-	return os.Remove(l.absolutePath(key))
+	return l.remove(key)
 }
 
 // Exists file
 func (l *Local) Exists(key string) (bool, error) {
-	info, err := os.Stat(l.absolutePath(key))
+	path, err := l.absolutePath(key)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(path)
 	if os.IsNotExist(err) {
 		return false, nil
 	}
@@ -105,11 +266,15 @@ func (l *Local) Exists(key string) (bool, error) {
 func (l *Local) List(prefix string) ([]*store.KVPair, error) {
 	var kv []*store.KVPair
 
-	err := filepath.Walk(l.absolutePath(prefix), func(path string, info os.FileInfo, err error) error {
+	root, err := l.absolutePath(prefix)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if info == nil {
 			return store.ErrKeyNotFound
 		}
-		if info.IsDir() {
+		if info.IsDir() || hidden(path) {
 			return nil
 		}
 
@@ -130,7 +295,16 @@ func (l *Local) Close() {
 
 // DeleteTree remove
 func (l *Local) DeleteTree(prefix string) error {
-	return os.RemoveAll(l.absolutePath(prefix))
+	unlock, err := l.lockBucket()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	path, err := l.absolutePath(prefix)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
 }
 
 // NewLock is not implemented
@@ -138,22 +312,202 @@ func (l *Local) NewLock(key string, options *store.LockOptions) (store.Locker, e
 	return nil, store.ErrCallNotSupported
 }
 
-// Watch  is not implemented
+// watchDelay collects the events of a write before the watchers are notified
+const watchDelay = 50 * time.Millisecond
+
+// watcher creates a filesystem watcher for the directory, recursive also watches all subdirectories
+func (l *Local) watcher(dir string, recursive bool) (*fsnotify.Watcher, error) {
+	if err := l.checkPath(dir); err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := addWatch(watcher, dir, recursive); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return watcher, nil
+}
+
+// addWatch adds the directory and optional its subdirectories to the watcher
+func addWatch(watcher *fsnotify.Watcher, dir string, recursive bool) error {
+	if !recursive {
+		return watcher.Add(dir)
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// notify calls fn after matching events until the stop channel is closed or fn returns false.
+// A file write causes multiple events, fn is called once the events have settled.
+func notify(watcher *fsnotify.Watcher, recursive bool, stopCh <-chan struct{}, match func(path string) bool, fn func() bool) {
+	defer watcher.Close()
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-stopCh:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if recursive && event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					addWatch(watcher, event.Name, true)
+				}
+			}
+			if match(event.Name) {
+				pending = time.After(watchDelay)
+			}
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+		case <-pending:
+			pending = nil
+			if !fn() {
+				return
+			}
+		}
+	}
+}
+
+// Watch sends the value of the key and every change of the file
 func (l *Local) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
-	return nil, store.ErrCallNotSupported
+	path, err := l.absolutePath(key)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := l.watcher(filepath.Dir(path), false)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *store.KVPair)
+	send := func() bool {
+		pair, err := l.Get(key)
+		if err != nil {
+			// removed files are not sent
+			return true
+		}
+		select {
+		case watchCh <- pair:
+			return true
+		case <-stopCh:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(watchCh)
+		if !send() {
+			watcher.Close()
+			return
+		}
+		notify(watcher, false, stopCh, func(name string) bool { return name == path }, send)
+	}()
+	return watchCh, nil
 }
 
-// WatchTree  is not implemented
+// WatchTree sends all values below the directory on every change of a file within
 func (l *Local) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
-	return nil, store.ErrCallNotSupported
+	root, err := l.absolutePath(prefix)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := l.watcher(root, true)
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan []*store.KVPair)
+	send := func() bool {
+		pairs, err := l.List(prefix)
+		if err != nil && err != store.ErrKeyNotFound {
+			return true
+		}
+		if pairs == nil {
+			pairs = []*store.KVPair{}
+		}
+		select {
+		case watchCh <- pairs:
+			return true
+		case <-stopCh:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(watchCh)
+		if !send() {
+			watcher.Close()
+			return
+		}
+		notify(watcher, true, stopCh, func(name string) bool { return !hidden(name) }, send)
+	}()
+	return watchCh, nil
 }
 
-// AtomicPut is not implemented
+// AtomicPut writes the value if the version has not been changed, previous nil only creates a new file
 func (l *Local) AtomicPut(key string, value []byte, previous *store.KVPair, opts *store.WriteOptions) (bool, *store.KVPair, error) {
-	return false, nil, store.ErrCallNotSupported
+	unlock, err := l.lockBucket()
+	if err != nil {
+		return false, nil, err
+	}
+	defer unlock()
+
+	current, err := l.get(key)
+	switch {
+	case err != nil && err != store.ErrKeyNotFound:
+		return false, nil, err
+	case previous == nil && current != nil:
+		return false, nil, store.ErrKeyExists
+	case previous != nil && current == nil:
+		return false, nil, store.ErrKeyNotFound
+	case previous != nil && current.LastIndex != previous.LastIndex:
+		return false, nil, store.ErrKeyModified
+	}
+
+	var index uint64
+	if current != nil {
+		index = current.LastIndex
+	}
+	pair, err := l.put(key, value, index)
+	if err != nil {
+		return false, nil, err
+	}
+	return true, pair, nil
 }
 
-// AtomicDelete is not implemented
+// AtomicDelete removes the file if the version has not been changed
 func (l *Local) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
-	return false, store.ErrCallNotSupported
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	unlock, err := l.lockBucket()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	current, err := l.get(key)
+	if err != nil {
+		return false, err
+	}
+	if current.LastIndex != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	if err := l.remove(key); err != nil {
+		return false, err
+	}
+	return true, nil
 }
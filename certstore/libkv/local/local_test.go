@@ -2,6 +2,9 @@ package local
 
 import (
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/docker/libkv"
@@ -50,8 +53,8 @@ func TestLocalStore(t *testing.T) {
 	kv := makeStore(t, tmpdir)
 	//lockKV := makeStore(t)
 	testutils.RunTestCommon(t, kv)
-	//testutils.RunTestAtomic(t, kv)
-	//testutils.RunTestWatch(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunTestWatch(t, kv)
 	//testutils.RunTestLock(t, kv)
 	//testutils.RunTestLockTTL(t, kv, lockKV)
 	//testutils.RunTestTTL(t, kv, ttlKV)
@@ -59,3 +62,63 @@ func TestLocalStore(t *testing.T) {
 
 	os.RemoveAll(tmpdir)
 }
+
+func TestLocalAtomicPutConcurrent(t *testing.T) {
+	kv := makeStore(t, t.TempDir())
+	assert.NoError(t, kv.Put("certs/counter", []byte("0"), nil))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				pair, err := kv.Get("certs/counter")
+				assert.NoError(t, err)
+				n, _ := strconv.Atoi(string(pair.Value))
+				ok, _, err := kv.AtomicPut("certs/counter", []byte(strconv.Itoa(n+1)), pair, nil)
+				if err == store.ErrKeyModified {
+					continue
+				}
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				return
+			}
+		}()
+	}
+	wg.Wait()
+
+	pair, err := kv.Get("certs/counter")
+	assert.NoError(t, err)
+	assert.Equal(t, "10", string(pair.Value))
+
+	// the version files are not listed
+	pairs, err := kv.List("certs")
+	assert.NoError(t, err)
+	assert.Len(t, pairs, 1)
+}
+
+func TestLocalPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	kv := makeStore(t, filepath.Join(dir, "storage"))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0600))
+
+	for _, key := range []string{"../secret", "certs/../../secret", "/../secret", "certs/../.."} {
+		_, err := kv.Get(key)
+		assert.Equal(t, ErrInvalidKey, err, key)
+		assert.Equal(t, ErrInvalidKey, kv.Put(key, []byte("overwrite"), nil), key)
+		assert.Equal(t, ErrInvalidKey, kv.Delete(key), key)
+		assert.Equal(t, ErrInvalidKey, kv.DeleteTree(key), key)
+		_, err = kv.List(key)
+		assert.Equal(t, ErrInvalidKey, err, key)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "secret"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(data))
+
+	// keys are still relative to the bucket after cleaning
+	assert.NoError(t, kv.Put("certs/../user.json", []byte("user"), nil))
+	pair, err := kv.Get("user.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", string(pair.Value))
+}
@@ -0,0 +1,354 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+)
+
+const S3 = "s3"
+
+// ObjectStore "libkv" store using an S3 compatible object storage.
+// The index of a key is derived from the ETag of the object, conditional writes are used for AtomicPut.
+type ObjectStore struct {
+	client  *awss3.Client
+	bucket  string
+	prefix  string
+	timeout time.Duration
+
+	// Region overwrites the region of the aws configuration
+	Region string
+	// ServerSideEncryption is the encryption applied by the storage (AES256, aws:kms), empty uses the bucket default
+	ServerSideEncryption string
+	// KMSKeyID is the kms key used for aws:kms encryption
+	KMSKeyID string
+}
+
+// Register registers s3 to libkv
+func Register() {
+	libkv.AddStore(S3, New)
+}
+
+// New creates a s3 store, the bucket is the name of the bucket followed by an optional key prefix (bucket/prefix).
+// The first address is the endpoint of S3 compatible storages like MinIO or Ceph, path style addressing is used for them.
+// Username and password are the access key and secret, otherwise the default aws credential chain is used.
+func New(addrs []string, options *store.Config) (store.Store, error) {
+	if options == nil {
+		options = &store.Config{}
+	}
+	bucket, prefix, _ := strings.Cut(strings.Trim(options.Bucket, "/"), "/")
+	if bucket == "" {
+		return nil, errors.New("s3 bucket is not set")
+	}
+
+	loadOptions := []func(*config.LoadOptions) error{
+		// many S3 compatible storages do not support the default checksums of the sdk
+		config.WithRequestChecksumCalculation(aws.RequestChecksumCalculationWhenRequired),
+		config.WithResponseChecksumValidation(aws.ResponseChecksumValidationWhenRequired),
+	}
+	if options.Username != "" {
+		loadOptions = append(loadOptions, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(options.Username, options.Password, "")))
+	}
+	if options.TLS != nil {
+		loadOptions = append(loadOptions, config.WithHTTPClient(&http.Client{Transport: &http.Transport{TLSClientConfig: options.TLS}}))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), loadOptions...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	client := awss3.NewFromConfig(cfg, func(o *awss3.Options) {
+		if len(addrs) > 0 && addrs[0] != "" {
+			o.BaseEndpoint = aws.String(addrs[0])
+			o.UsePathStyle = true
+		}
+	})
+
+	return &ObjectStore{
+		client:  client,
+		bucket:  bucket,
+		prefix:  prefix,
+		timeout: options.ConnectionTimeout,
+	}, nil
+}
+
+// normalize removes leading and trailing slashes, directories are only a prefix of the object keys
+func normalize(key string) string {
+	return strings.Trim(key, "/")
+}
+
+func (s *ObjectStore) objectKey(key string) string {
+	return strings.TrimPrefix(s.prefix+"/"+normalize(key), "/")
+}
+
+func (s *ObjectStore) context() (context.Context, context.CancelFunc) {
+	if s.timeout > 0 {
+		return context.WithTimeout(context.Background(), s.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (s *ObjectStore) optFns() []func(*awss3.Options) {
+	return []func(*awss3.Options){func(o *awss3.Options) {
+		if s.Region != "" {
+			o.Region = s.Region
+		}
+	}}
+}
+
+// index converts the etag into the libkv index
+func index(etag *string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(aws.ToString(etag)))
+	return h.Sum64()
+}
+
+// convertError maps the api errors to libkv errors
+func convertError(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.ErrorCode() {
+	case "NotFound", "NoSuchKey":
+		return store.ErrKeyNotFound
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return store.ErrKeyModified
+	}
+	return err
+}
+
+func (s *ObjectStore) head(key string) (*awss3.HeadObjectOutput, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	out, err := s.client.HeadObject(ctx, &awss3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	}, s.optFns()...)
+	return out, convertError(err)
+}
+
+// Get the object
+func (s *ObjectStore) Get(key string) (*store.KVPair, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	out, err := s.client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	}, s.optFns()...)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	defer out.Body.Close()
+
+	value, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &store.KVPair{Key: normalize(key), Value: value, LastIndex: index(out.ETag)}, nil
+}
+
+func (s *ObjectStore) put(key string, value []byte, ifMatch, ifNoneMatch *string) (*awss3.PutObjectOutput, error) {
+	input := &awss3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.objectKey(key)),
+		Body:          bytes.NewReader(value),
+		ContentLength: aws.Int64(int64(len(value))),
+		IfMatch:       ifMatch,
+		IfNoneMatch:   ifNoneMatch,
+	}
+	if s.ServerSideEncryption != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(s.ServerSideEncryption)
+	}
+	if s.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(s.KMSKeyID)
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+	out, err := s.client.PutObject(ctx, input, s.optFns()...)
+	return out, convertError(err)
+}
+
+// Put writes the object
+func (s *ObjectStore) Put(key string, value []byte, opts *store.WriteOptions) error {
+	_, err := s.put(key, value, nil, nil)
+	return err
+}
+
+// Delete the object
+func (s *ObjectStore) Delete(key string) error {
+	if ok, err := s.Exists(key); err != nil {
+		return err
+	} else if !ok {
+		return store.ErrKeyNotFound
+	}
+	return s.delete(s.objectKey(key))
+}
+
+func (s *ObjectStore) delete(objectKey string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.client.DeleteObject(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(objectKey),
+	}, s.optFns()...)
+	return convertError(err)
+}
+
+// Exists checks if the object exists
+func (s *ObjectStore) Exists(key string) (bool, error) {
+	_, err := s.head(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// objectKeys lists all object keys below the directory
+func (s *ObjectStore) objectKeys(directory string) ([]string, error) {
+	prefix := s.objectKey(directory)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	keys := []string{}
+	paginator := awss3.NewListObjectsV2Paginator(s.client, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		ctx, cancel := s.context()
+		page, err := paginator.NextPage(ctx, s.optFns()...)
+		cancel()
+		if err != nil {
+			return nil, convertError(err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return keys, nil
+}
+
+// List all objects below the directory
+func (s *ObjectStore) List(directory string) ([]*store.KVPair, error) {
+	objectKeys, err := s.objectKeys(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := []*store.KVPair{}
+	for _, objectKey := range objectKeys {
+		pair, err := s.Get(strings.TrimPrefix(objectKey, s.prefix))
+		if err == store.ErrKeyNotFound {
+			// deleted in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// DeleteTree removes all objects below the directory
+func (s *ObjectStore) DeleteTree(directory string) error {
+	objectKeys, err := s.objectKeys(directory)
+	if err != nil {
+		return err
+	}
+	for _, objectKey := range objectKeys {
+		if err := s.delete(objectKey); err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// AtomicPut writes the object with a conditional request, previous nil only creates a new object
+func (s *ObjectStore) AtomicPut(key string, value []byte, previous *store.KVPair, opts *store.WriteOptions) (bool, *store.KVPair, error) {
+	var out *awss3.PutObjectOutput
+	var err error
+
+	if previous == nil {
+		out, err = s.put(key, value, nil, aws.String("*"))
+		if err == store.ErrKeyModified {
+			return false, nil, store.ErrKeyExists
+		}
+	} else {
+		var current *awss3.HeadObjectOutput
+		current, err = s.head(key)
+		if err != nil {
+			return false, nil, err
+		}
+		if index(current.ETag) != previous.LastIndex {
+			return false, nil, store.ErrKeyModified
+		}
+		// the etag guards against changes since the head request
+		out, err = s.put(key, value, current.ETag, nil)
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return true, &store.KVPair{Key: normalize(key), Value: value, LastIndex: index(out.ETag)}, nil
+}
+
+// AtomicDelete removes the object if it has not been changed.
+// Conditional deletes are not supported by all storages, the index is compared before deleting.
+func (s *ObjectStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	current, err := s.head(key)
+	if err != nil {
+		return false, err
+	}
+	if index(current.ETag) != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	if err := s.delete(s.objectKey(key)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewLock is not implemented
+func (s *ObjectStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Watch is not implemented
+func (s *ObjectStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// WatchTree is not implemented
+func (s *ObjectStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Close is not required but needs to be implemented
+func (s *ObjectStore) Close() {
+}
@@ -0,0 +1,170 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeObject struct {
+	data    []byte
+	etag    string
+	headers http.Header
+}
+
+// fakeS3 implements the subset of the S3 api used by the store with path style addressing
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string]*fakeObject
+}
+
+type listResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	MaxKeys     int
+	IsTruncated bool
+	Contents    []listContent
+}
+
+type listContent struct {
+	Key  string
+	ETag string
+	Size int
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	object := f.objects[key]
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		prefix := r.URL.Query().Get("prefix")
+		result := listResult{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+		keys := []string{}
+		for existing := range f.objects {
+			if strings.HasPrefix(existing, prefix) {
+				keys = append(keys, existing)
+			}
+		}
+		sort.Strings(keys)
+		for _, existing := range keys {
+			result.Contents = append(result.Contents, listContent{Key: existing, ETag: f.objects[existing].etag, Size: len(f.objects[existing].data)})
+		}
+		result.KeyCount = len(result.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		if object == nil {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", object.etag)
+		w.Header().Set("Content-Length", fmt.Sprint(len(object.data)))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case r.Method == http.MethodPut:
+		if r.Header.Get("If-None-Match") == "*" && object != nil {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && (object == nil || object.etag != match) {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		sum := md5.Sum(data)
+		f.objects[key] = &fakeObject{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`, headers: r.Header.Clone()}
+		w.Header().Set("ETag", f.objects[key].etag)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func makeStore(t *testing.T, bucket string) (*ObjectStore, *fakeS3) {
+	fake := &fakeS3{bucket: "certjunkie", objects: map[string]*fakeObject{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	kv, err := New([]string{server.URL}, &store.Config{Bucket: bucket, Username: "access", Password: "secret"})
+	if err != nil {
+		t.Fatalf("cannot create store: %v", err)
+	}
+	return kv.(*ObjectStore), fake
+}
+
+func TestRegister(t *testing.T) {
+	Register()
+
+	kv, err := libkv.NewStore(S3, []string{}, &store.Config{Bucket: "certjunkie"})
+	assert.NoError(t, err)
+	if _, ok := kv.(*ObjectStore); !ok {
+		t.Fatal("Error registering and initializing s3")
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	kv, _ := makeStore(t, "certjunkie")
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunCleanup(t, kv)
+}
+
+func TestS3PrefixAndEncryption(t *testing.T) {
+	kv, fake := makeStore(t, "certjunkie/prod")
+	kv.ServerSideEncryption = "aws:kms"
+	kv.KMSKeyID = "alias/certjunkie"
+
+	assert.NoError(t, kv.Put("/certs/example.com.json", []byte(`{"domain":"example.com"}`), nil))
+	if assert.Contains(t, fake.objects, "prod/certs/example.com.json") {
+		headers := fake.objects["prod/certs/example.com.json"].headers
+		assert.Equal(t, "aws:kms", headers.Get("X-Amz-Server-Side-Encryption"))
+		assert.Equal(t, "alias/certjunkie", headers.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	}
+
+	pairs, err := kv.List("certs/")
+	assert.NoError(t, err)
+	if assert.Len(t, pairs, 1) {
+		assert.Equal(t, "certs/example.com.json", pairs[0].Key)
+		assert.Equal(t, `{"domain":"example.com"}`, string(pairs[0].Value))
+	}
+
+	_, err = kv.Get("user.json")
+	assert.Equal(t, store.ErrKeyNotFound, err)
+}
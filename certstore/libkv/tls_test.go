@@ -0,0 +1,44 @@
+package libkv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/internal/testcert"
+)
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca, _ := testcert.New(t, time.Now(), time.Now().Add(time.Hour), "ca.example.com")
+	cert, key := testcert.New(t, time.Now(), time.Now().Add(time.Hour), "client.example.com")
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(caFile, ca, 0600))
+	assert.NoError(t, os.WriteFile(certFile, cert, 0600))
+	assert.NoError(t, os.WriteFile(keyFile, key, 0600))
+
+	config, err := TLSConfig("", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, config)
+
+	config, err = TLSConfig(caFile, certFile, keyFile)
+	assert.NoError(t, err)
+	assert.NotNil(t, config.RootCAs)
+	assert.Len(t, config.Certificates, 1)
+
+	// server verification only
+	config, err = TLSConfig(caFile, "", "")
+	assert.NoError(t, err)
+	assert.NotNil(t, config.RootCAs)
+	assert.Empty(t, config.Certificates)
+
+	_, err = TLSConfig("", certFile, "")
+	assert.Error(t, err)
+	_, err = TLSConfig(keyFile, "", "")
+	assert.Error(t, err)
+	_, err = TLSConfig(filepath.Join(dir, "missing.pem"), "", "")
+	assert.Error(t, err)
+}
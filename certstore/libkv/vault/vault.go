@@ -0,0 +1,340 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+)
+
+const VAULT = "vault"
+
+// Vault "libkv" store using a HashiCorp Vault KV version 2 secrets engine.
+// Values are stored base64 encoded in the field "value" of the secret,
+// the version of the secret is used as index for check-and-set.
+type Vault struct {
+	client    *http.Client
+	address   string
+	token     string
+	namespace string
+	mount     string
+	prefix    string
+}
+
+// Register registers vault to libkv
+func Register() {
+	libkv.AddStore(VAULT, New)
+}
+
+// New creates a vault store, the bucket is the mount of the kv engine followed by an optional path prefix (mount/prefix).
+// The address and token default to the VAULT_ADDR and VAULT_TOKEN environment variables.
+func New(addrs []string, options *store.Config) (store.Store, error) {
+	if options == nil {
+		options = &store.Config{}
+	}
+
+	v := &Vault{
+		client:    &http.Client{Timeout: options.ConnectionTimeout},
+		address:   os.Getenv("VAULT_ADDR"),
+		token:     options.Password,
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		mount:     "secret",
+	}
+	if len(addrs) > 0 && addrs[0] != "" {
+		v.address = addrs[0]
+	}
+	if v.address == "" {
+		return nil, errors.New("vault address is not set")
+	}
+	if !strings.Contains(v.address, "://") {
+		v.address = "https://" + v.address
+	}
+	v.address = strings.TrimSuffix(v.address, "/")
+	if v.token == "" {
+		v.token = os.Getenv("VAULT_TOKEN")
+	}
+	if options.TLS != nil {
+		v.client.Transport = &http.Transport{TLSClientConfig: options.TLS}
+	}
+	if bucket := strings.Trim(options.Bucket, "/"); bucket != "" {
+		v.mount, v.prefix, _ = strings.Cut(bucket, "/")
+	}
+	return v, nil
+}
+
+// normalize removes leading and trailing slashes, vault does not distinguish them
+func normalize(key string) string {
+	return strings.Trim(key, "/")
+}
+
+// url returns the api path of the key below the kind (data or metadata)
+func (v *Vault) url(kind, key string) string {
+	segments := []string{"v1", v.mount, kind}
+	for _, part := range strings.Split(strings.Trim(v.prefix+"/"+normalize(key), "/"), "/") {
+		if part != "" {
+			segments = append(segments, url.PathEscape(part))
+		}
+	}
+	return v.address + "/" + strings.Join(segments, "/")
+}
+
+// apiError is the error response of vault
+type apiError struct {
+	StatusCode int
+	Errors     []string `json:"errors"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("vault returned %d: %s", e.StatusCode, strings.Join(e.Errors, ", "))
+}
+
+func (v *Vault) do(method, url string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return store.ErrKeyNotFound
+	}
+	if resp.StatusCode >= 300 {
+		apiErr := &apiError{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type kvData struct {
+	Value []byte `json:"value"`
+}
+
+type readResponse struct {
+	Data struct {
+		Data     *kvData `json:"data"`
+		Metadata struct {
+			Version uint64 `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+type writeRequest struct {
+	Data    kvData            `json:"data"`
+	Options map[string]uint64 `json:"options,omitempty"`
+}
+
+type writeResponse struct {
+	Data struct {
+		Version uint64 `json:"version"`
+	} `json:"data"`
+}
+
+type listResponse struct {
+	Data struct {
+		Keys []string `json:"keys"`
+	} `json:"data"`
+}
+
+// Get the latest version of the secret
+func (v *Vault) Get(key string) (*store.KVPair, error) {
+	resp := &readResponse{}
+	if err := v.do(http.MethodGet, v.url("data", key), nil, resp); err != nil {
+		return nil, err
+	}
+	// deleted versions have no data
+	if resp.Data.Data == nil {
+		return nil, store.ErrKeyNotFound
+	}
+	value := resp.Data.Data.Value
+	if value == nil {
+		value = []byte{}
+	}
+	return &store.KVPair{Key: normalize(key), Value: value, LastIndex: resp.Data.Metadata.Version}, nil
+}
+
+func (v *Vault) write(key string, value []byte, options map[string]uint64) (uint64, error) {
+	resp := &writeResponse{}
+	err := v.do(http.MethodPost, v.url("data", key), &writeRequest{Data: kvData{Value: value}, Options: options}, resp)
+	return resp.Data.Version, err
+}
+
+// Put writes a new version of the secret
+func (v *Vault) Put(key string, value []byte, opts *store.WriteOptions) error {
+	_, err := v.write(key, value, nil)
+	return err
+}
+
+// Delete removes all versions and the metadata of the secret
+func (v *Vault) Delete(key string) error {
+	if ok, err := v.Exists(key); err != nil {
+		return err
+	} else if !ok {
+		return store.ErrKeyNotFound
+	}
+	return v.do(http.MethodDelete, v.url("metadata", key), nil, nil)
+}
+
+// Exists checks if the secret has a readable version
+func (v *Vault) Exists(key string) (bool, error) {
+	_, err := v.Get(key)
+	if err == store.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// keys lists the keys below the directory recursively
+func (v *Vault) keys(directory string) ([]string, error) {
+	resp := &listResponse{}
+	if err := v.do(http.MethodGet, v.url("metadata", directory)+"/?list=true", nil, resp); err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, name := range resp.Data.Keys {
+		key := strings.TrimPrefix(normalize(directory)+"/"+name, "/")
+		if !strings.HasSuffix(name, "/") {
+			keys = append(keys, key)
+			continue
+		}
+		children, err := v.keys(key)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, children...)
+	}
+	return keys, nil
+}
+
+// List all secrets below the directory
+func (v *Vault) List(directory string) ([]*store.KVPair, error) {
+	keys, err := v.keys(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := []*store.KVPair{}
+	for _, key := range keys {
+		pair, err := v.Get(key)
+		if err == store.ErrKeyNotFound {
+			// deleted in the meantime or only deleted versions left
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// DeleteTree removes all secrets below the directory
+func (v *Vault) DeleteTree(directory string) error {
+	keys, err := v.keys(directory)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := v.do(http.MethodDelete, v.url("metadata", key), nil, nil); err != nil && err != store.ErrKeyNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// AtomicPut writes the secret with check-and-set, previous nil only creates a new secret
+func (v *Vault) AtomicPut(key string, value []byte, previous *store.KVPair, opts *store.WriteOptions) (bool, *store.KVPair, error) {
+	cas := uint64(0)
+	if previous != nil {
+		cas = previous.LastIndex
+	}
+
+	version, err := v.write(key, value, map[string]uint64{"cas": cas})
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest && strings.Contains(apiErr.Error(), "check-and-set") {
+		if previous == nil {
+			return false, nil, store.ErrKeyExists
+		}
+		return false, nil, store.ErrKeyModified
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return true, &store.KVPair{Key: normalize(key), Value: value, LastIndex: version}, nil
+}
+
+// AtomicDelete removes the secret if the version has not been changed.
+// Vault does not support check-and-set for deletes, the version is compared before deleting.
+func (v *Vault) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	current, err := v.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if current.LastIndex != previous.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	if err := v.do(http.MethodDelete, v.url("metadata", key), nil, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewLock is not implemented
+func (v *Vault) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Watch is not implemented
+func (v *Vault) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// WatchTree is not implemented
+func (v *Vault) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Close the idle connections
+func (v *Vault) Close() {
+	v.client.CloseIdleConnections()
+}
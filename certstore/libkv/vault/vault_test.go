@@ -0,0 +1,155 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSecret struct {
+	data    json.RawMessage
+	version uint64
+}
+
+// fakeVault implements the subset of the kv version 2 api used by the store
+type fakeVault struct {
+	mu      sync.Mutex
+	secrets map[string]*fakeSecret
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("X-Vault-Token") != "root" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/secret/")
+	switch {
+	case strings.HasPrefix(path, "data/"):
+		key := strings.TrimPrefix(path, "data/")
+		secret := f.secrets[key]
+		switch r.Method {
+		case http.MethodGet:
+			if secret == nil {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[]}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+				"data":     secret.data,
+				"metadata": map[string]interface{}{"version": secret.version},
+			}})
+		case http.MethodPost:
+			req := struct {
+				Data    json.RawMessage   `json:"data"`
+				Options map[string]uint64 `json:"options"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&req)
+			version := uint64(0)
+			if secret != nil {
+				version = secret.version
+			}
+			if cas, ok := req.Options["cas"]; ok && cas != version {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors":["check-and-set parameter did not match the current version"]}`))
+				return
+			}
+			f.secrets[key] = &fakeSecret{data: req.Data, version: version + 1}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"version": version + 1}})
+		}
+	case strings.HasPrefix(path, "metadata/"):
+		key := strings.TrimPrefix(path, "metadata/")
+		if r.Method == http.MethodDelete {
+			delete(f.secrets, key)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.URL.Query().Get("list") != "true" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		names := map[string]bool{}
+		for existing := range f.secrets {
+			if !strings.HasPrefix(existing, key) {
+				continue
+			}
+			name, _, isDir := strings.Cut(strings.TrimPrefix(existing, key), "/")
+			if isDir {
+				name += "/"
+			}
+			names[name] = true
+		}
+		if len(names) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		keys := []string{}
+		for name := range names {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func makeStore(t *testing.T, bucket string) (store.Store, *fakeVault) {
+	fake := &fakeVault{secrets: map[string]*fakeSecret{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	kv, err := New([]string{server.URL}, &store.Config{Bucket: bucket, Password: "root"})
+	if err != nil {
+		t.Fatalf("cannot create store: %v", err)
+	}
+	return kv, fake
+}
+
+func TestRegister(t *testing.T) {
+	Register()
+
+	kv, err := libkv.NewStore(VAULT, []string{"http://127.0.0.1:8200"}, nil)
+	assert.NoError(t, err)
+	if _, ok := kv.(*Vault); !ok {
+		t.Fatal("Error registering and initializing vault")
+	}
+}
+
+func TestVaultStore(t *testing.T) {
+	kv, _ := makeStore(t, "secret")
+	testutils.RunTestCommon(t, kv)
+	testutils.RunTestAtomic(t, kv)
+	testutils.RunCleanup(t, kv)
+}
+
+func TestVaultPrefix(t *testing.T) {
+	kv, fake := makeStore(t, "secret/certjunkie")
+
+	assert.NoError(t, kv.Put("/certs/example.com.json", []byte(`{"domain":"example.com"}`), nil))
+	assert.Contains(t, fake.secrets, "certjunkie/certs/example.com.json")
+
+	pairs, err := kv.List("certs/")
+	assert.NoError(t, err)
+	if assert.Len(t, pairs, 1) {
+		assert.Equal(t, "certs/example.com.json", pairs[0].Key)
+		assert.Equal(t, `{"domain":"example.com"}`, string(pairs[0].Value))
+	}
+
+	_, err = kv.Get("user.json")
+	assert.Equal(t, store.ErrKeyNotFound, err)
+}
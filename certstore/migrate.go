@@ -0,0 +1,159 @@
+package certstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/rs/zerolog/log"
+)
+
+// storedDirectories contain the certificate records
+var storedDirectories = []string{"certs/", "csr/", historyDirectory}
+
+// storedKeys returns the keys of the account and all certificate records in the storage
+func storedKeys(storage store.Store) ([]string, error) {
+	keys := []string{pathUser}
+	for _, directory := range storedDirectories {
+		pairs, err := storage.List(directory)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs {
+			keys = append(keys, pair.Key)
+		}
+	}
+	return keys, nil
+}
+
+// isStoredKey checks if the key belongs to the account or a certificate record
+func isStoredKey(key string) bool {
+	if key == pathUser {
+		return true
+	}
+	if strings.Contains(key, "..") {
+		return false
+	}
+	for _, directory := range storedDirectories {
+		if strings.HasPrefix(key, directory) && strings.HasSuffix(key, ".json") {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateResult counts the records of a migration
+type MigrateResult struct {
+	// Copied records, in dry run mode the records which would be copied
+	Copied int
+	// Skipped records already exist with the same content in the target
+	Skipped int
+	// Invalid source records are not copied
+	Invalid []string
+}
+
+// Migrate copies the account and all certificate records from one storage to another.
+// Every copied record is read again from the target and parsed to verify it.
+// Records with the same content in the target and invalid source records are skipped,
+// an interrupted migration can be started again.
+func Migrate(from, to store.Store, dryRun bool) (*MigrateResult, error) {
+	keys, err := storedKeys(from)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list source: %v", err)
+	}
+
+	result := &MigrateResult{}
+	for _, key := range keys {
+		pair, err := from.Get(key)
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("cannot read %s: %v", key, err)
+		}
+		logger := log.With().Str("key", key).Bool("dry_run", dryRun).Logger()
+		if err := verifyRecord(key, pair.Value); err != nil {
+			logger.Warn().Err(err).Msg("skip invalid source record")
+			result.Invalid = append(result.Invalid, indexKey(key))
+			continue
+		}
+
+		existing, err := to.Get(key)
+		if err != nil && err != store.ErrKeyNotFound {
+			return result, fmt.Errorf("cannot read target %s: %v", key, err)
+		}
+		if existing != nil && sameRecord(existing.Value, pair.Value) {
+			logger.Debug().Msg("record already migrated")
+			result.Skipped++
+			continue
+		}
+
+		logger.Info().Msg("migrate record")
+		result.Copied++
+		if dryRun {
+			continue
+		}
+
+		if err := to.Put(key, pair.Value, nil); err != nil {
+			return result, fmt.Errorf("cannot write %s: %v", key, err)
+		}
+		written, err := to.Get(key)
+		if err != nil {
+			return result, fmt.Errorf("cannot read written %s: %v", key, err)
+		}
+		if !sameRecord(written.Value, pair.Value) {
+			return result, fmt.Errorf("written record %s differs from the source", key)
+		}
+		if err := verifyRecord(key, written.Value); err != nil {
+			return result, fmt.Errorf("invalid written record %s: %v", key, err)
+		}
+	}
+	return result, nil
+}
+
+// sameRecord compares the json records regardless of the field order
+func sameRecord(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var valueA, valueB interface{}
+	if json.Unmarshal(a, &valueA) != nil || json.Unmarshal(b, &valueB) != nil {
+		return false
+	}
+	return reflect.DeepEqual(valueA, valueB)
+}
+
+// verifyRecord parses the stored account or certificate
+func verifyRecord(key string, value []byte) error {
+	key = indexKey(key)
+	if key == pathUser {
+		user := &User{}
+		if err := json.Unmarshal(value, user); err != nil {
+			return err
+		}
+		if len(user.Key) == 0 {
+			return fmt.Errorf("account has no private key")
+		}
+		return nil
+	}
+
+	cert, err := decodeRecord(value)
+	if err != nil {
+		return err
+	}
+	if _, err := cert.ParseCertificate(); err != nil {
+		return err
+	}
+	// certificates of signing requests have no private key
+	csr := strings.HasPrefix(key, "csr/") || strings.HasPrefix(key, historyDirectory+"csr/")
+	if len(cert.PrivateKey) == 0 && !csr {
+		return fmt.Errorf("certificate has no private key")
+	}
+	return nil
+}
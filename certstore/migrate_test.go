@@ -0,0 +1,53 @@
+package certstore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore/libkv/local"
+)
+
+func TestMigrate(t *testing.T) {
+	from, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+	to, _ := local.New(nil, &store.Config{Bucket: t.TempDir()})
+
+	user, _ := json.Marshal(&User{Email: "test@example.com", Key: []byte("account key")})
+	assert.NoError(t, from.Put(pathUser, user, nil))
+	for _, domain := range []string{"example.com", "example.org"} {
+		value, _ := json.Marshal(testCertificate(t, domain))
+		assert.NoError(t, from.Put("certs/"+domain+".json", value, nil))
+	}
+
+	result, err := Migrate(from, to, true)
+	assert.NoError(t, err)
+	assert.Equal(t, &MigrateResult{Copied: 3}, result)
+	exists, _ := to.Exists(pathUser)
+	assert.False(t, exists)
+
+	// interrupted migration
+	assert.NoError(t, to.Put(pathUser, user, nil))
+
+	result, err = Migrate(from, to, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &MigrateResult{Copied: 2, Skipped: 1}, result)
+	pair, err := to.Get("certs/example.org.json")
+	assert.NoError(t, err)
+	assert.NoError(t, verifyRecord(pair.Key, pair.Value))
+
+	result, err = Migrate(from, to, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &MigrateResult{Skipped: 3}, result)
+
+	// broken records are not copied, the others are migrated anyway
+	assert.NoError(t, from.Put("certs/broken.json", []byte(`{"domain":"broken"}`), nil))
+	value, _ := json.Marshal(testCertificate(t, "example.net"))
+	assert.NoError(t, from.Put("certs/example.net.json", value, nil))
+	result, err = Migrate(from, to, false)
+	assert.NoError(t, err)
+	assert.Equal(t, &MigrateResult{Copied: 1, Skipped: 3, Invalid: []string{"certs/broken.json"}}, result)
+	exists, _ = to.Exists("certs/broken.json")
+	assert.False(t, exists)
+}
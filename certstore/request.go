@@ -1,6 +1,7 @@
 package certstore
 
 import (
+	"crypto/x509"
 	"strings"
 	"time"
 
@@ -13,44 +14,59 @@ type CertRequest struct {
 	DomainIsCn bool     `json:"onlycn"`
 	ValidDays  int      `json:"valid"`
 	San        []string `json:"san"`
+	// ReuseKey overrides the default of the store to reuse the private key on renewal
+	ReuseKey *bool `json:"reusekey,omitempty"`
 }
 
 func (r *CertRequest) pathCert() string {
 	return "certs/" + strings.ToLower(r.Domain) + ".json"
 }
 
+func (r *CertRequest) reuseKey(defaultValue bool) bool {
+	if r.ReuseKey != nil {
+		return *r.ReuseKey
+	}
+	return defaultValue
+}
+
 func (r *CertRequest) domains() []string {
 	// First element in the list will get the common name
 	return removeDuplicates(append([]string{r.Domain}, r.San...))
 }
 
-func (r *CertRequest) matchCertificate(cert *CertificateResource) (bool, error) {
-	// First element in the list will get the common name
-
-	certInfo, err := cert.parseCert()
+// MatchCertificate checks if the cert covers all requested domains and is valid long enough
+func (r *CertRequest) MatchCertificate(cert *CertificateResource) (bool, error) {
+	certInfo, err := cert.ParseCertificate()
 	if err != nil {
 		return false, err
 	}
+	return r.matchCertificate(certInfo), nil
+}
 
-	matches := 0
-	for _, host := range r.domains() {
-		if certInfo.VerifyHostname(host) == nil {
-			matches += 1
-		}
-	}
-
-	if len(r.domains()) == matches {
+func (r *CertRequest) matchCertificate(certInfo *x509.Certificate) bool {
+	if len(r.MissingHostnames(certInfo)) == 0 {
 		// seems to be the perfect cert
 		validEndDay := time.Now().Add(time.Hour * time.Duration(24*r.ValidDays))
 		if certInfo.NotAfter.After(validEndDay) {
-			return true, nil
+			return true
 		}
 		// cert is expired
 		log.Info().Msgf("certificate is valid until %s but needs to be valid for %d days", certInfo.NotAfter, r.ValidDays)
-		return false, nil
+		return false
 	}
 
-	return false, nil
+	return false
+}
+
+// MissingHostnames returns the requested domains the cert is not valid for
+func (r *CertRequest) MissingHostnames(certInfo *x509.Certificate) []string {
+	missing := []string{}
+	for _, host := range r.domains() {
+		if certInfo.VerifyHostname(host) != nil {
+			missing = append(missing, host)
+		}
+	}
+	return missing
 }
 
 func removeDuplicates(elements []string) []string {
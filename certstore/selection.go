@@ -0,0 +1,104 @@
+package certstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SelectionCriterion prefers one of multiple stored certificates matching a request
+type SelectionCriterion string
+
+const (
+	// SelectExactCN prefers certificates with the requested domain as common name
+	SelectExactCN SelectionCriterion = "exact-cn"
+	// SelectFewestNames prefers certificates with the fewest names which have not been requested
+	SelectFewestNames SelectionCriterion = "fewest-names"
+	// SelectLatestExpiry prefers certificates valid the longest
+	SelectLatestExpiry SelectionCriterion = "latest-expiry"
+)
+
+// DefaultSelectionPolicy is used if the cert store has no policy configured
+var DefaultSelectionPolicy = []SelectionCriterion{SelectExactCN, SelectFewestNames, SelectLatestExpiry}
+
+// ParseSelectionPolicy reads the criteria in order of their priority
+func ParseSelectionPolicy(values []string) ([]SelectionCriterion, error) {
+	policy := []SelectionCriterion{}
+	for _, value := range values {
+		criterion := SelectionCriterion(strings.TrimSpace(value))
+		switch criterion {
+		case SelectExactCN, SelectFewestNames, SelectLatestExpiry:
+			policy = append(policy, criterion)
+		default:
+			return nil, fmt.Errorf("unknown selection criterion %q", value)
+		}
+	}
+	return policy, nil
+}
+
+// compare returns a negative number if a is preferred over b for the request
+func (s SelectionCriterion) compare(r *CertRequest, a, b *indexEntry) int {
+	switch s {
+	case SelectExactCN:
+		return boolOrder(exactCN(r, a), exactCN(r, b))
+	case SelectFewestNames:
+		return extraNames(r, a) - extraNames(r, b)
+	case SelectLatestExpiry:
+		return b.info.NotAfter.Compare(a.info.NotAfter)
+	}
+	return 0
+}
+
+func boolOrder(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return -1
+	}
+	return 1
+}
+
+func exactCN(r *CertRequest, entry *indexEntry) bool {
+	return strings.EqualFold(entry.info.Subject.CommonName, r.Domain)
+}
+
+// extraNames counts the names of the certificate which have not been requested
+func extraNames(r *CertRequest, entry *indexEntry) int {
+	requested := map[string]bool{}
+	for _, domain := range r.domains() {
+		requested[strings.ToLower(domain)] = true
+	}
+	extra := 0
+	for _, name := range entryNames(entry.info) {
+		if !requested[name] {
+			extra++
+		}
+	}
+	return extra
+}
+
+// selectEntry sorts the matching certificates by the policy, the storage key decides if all criteria are equal.
+// The criterion which preferred the selected certificate over the next one is returned as reason.
+func selectEntry(r *CertRequest, policy []SelectionCriterion, entries []*indexEntry) (*indexEntry, string) {
+	if len(entries) == 0 {
+		return nil, ""
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		for _, criterion := range policy {
+			if c := criterion.compare(r, entries[a], entries[b]); c != 0 {
+				return c < 0
+			}
+		}
+		return entries[a].key < entries[b].key
+	})
+	if len(entries) == 1 {
+		return entries[0], "only match"
+	}
+	for _, criterion := range policy {
+		if criterion.compare(r, entries[0], entries[1]) != 0 {
+			return entries[0], string(criterion)
+		}
+	}
+	return entries[0], "storage key"
+}
@@ -0,0 +1,60 @@
+package certstore
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEntry(key string, notAfter time.Time, cn string, names ...string) *indexEntry {
+	return &indexEntry{key: key, info: &x509.Certificate{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: append([]string{cn}, names...),
+		NotAfter: notAfter,
+	}}
+}
+
+func TestSelectEntry(t *testing.T) {
+	now := time.Now()
+	wildcard := testEntry("certs/*.example.com.json", now.Add(90*24*time.Hour), "*.example.com")
+	multi := testEntry("certs/multi.json", now.Add(60*24*time.Hour), "example.com", "a.example.com", "b.example.com", "c.example.com")
+	exact := testEntry("certs/a.example.com.json", now.Add(30*24*time.Hour), "a.example.com")
+	exactLater := testEntry("certs/a2.example.com.json", now.Add(40*24*time.Hour), "a.example.com")
+	r := &CertRequest{Domain: "a.example.com"}
+
+	entry, reason := selectEntry(r, DefaultSelectionPolicy, []*indexEntry{wildcard, multi, exact})
+	assert.Equal(t, exact, entry)
+	assert.Equal(t, "exact-cn", reason)
+
+	entry, reason = selectEntry(r, DefaultSelectionPolicy, []*indexEntry{multi, wildcard})
+	assert.Equal(t, wildcard, entry)
+	assert.Equal(t, "fewest-names", reason)
+
+	entry, reason = selectEntry(r, DefaultSelectionPolicy, []*indexEntry{exact, exactLater})
+	assert.Equal(t, exactLater, entry)
+	assert.Equal(t, "latest-expiry", reason)
+
+	entry, _ = selectEntry(r, []SelectionCriterion{SelectLatestExpiry}, []*indexEntry{exact, multi, wildcard})
+	assert.Equal(t, wildcard, entry)
+
+	// independent of the listed order
+	entry, reason = selectEntry(r, nil, []*indexEntry{exactLater, exact})
+	assert.Equal(t, exact, entry)
+	assert.Equal(t, "storage key", reason)
+
+	entry, reason = selectEntry(r, DefaultSelectionPolicy, []*indexEntry{multi})
+	assert.Equal(t, multi, entry)
+	assert.Equal(t, "only match", reason)
+}
+
+func TestParseSelectionPolicy(t *testing.T) {
+	policy, err := ParseSelectionPolicy([]string{"latest-expiry", " exact-cn"})
+	assert.NoError(t, err)
+	assert.Equal(t, []SelectionCriterion{SelectLatestExpiry, SelectExactCN}, policy)
+
+	_, err = ParseSelectionPolicy([]string{"newest"})
+	assert.Error(t, err)
+}
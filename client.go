@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"github.com/project0/certjunkie/api"
+	"github.com/project0/certjunkie/client"
+)
+
+// exitOffline is returned if certificates have been served from the local cache (EX_TEMPFAIL)
+const exitOffline = 75
+
+func clientCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "client",
+		Description: "client to retrieve cert bundle from an certjunkie api",
+		Subcommands: []*cli.Command{
+			clientCheckCommand(),
+		},
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "address",
+				Value:   "http://localhost:80",
+				Usage:   "CertJunkie api address",
+				EnvVars: flagSetHelperEnvKey("CLIENT_ADDRESS"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "failover",
+				Usage:   "Additional CertJunkie api addresses to use if the api is not available",
+				EnvVars: flagSetHelperEnvKey("CLIENT_FAILOVER"),
+			},
+			&cli.DurationFlag{
+				Name:    "timeout",
+				Value:   5 * time.Minute,
+				Usage:   "Timeout of a single api request, issuing a new certificate may take some minutes",
+				EnvVars: flagSetHelperEnvKey("CLIENT_TIMEOUT"),
+			},
+			&cli.IntFlag{
+				Name:    "retries",
+				Value:   3,
+				Usage:   "How often to retry all api addresses on connection failures or server errors",
+				EnvVars: flagSetHelperEnvKey("CLIENT_RETRIES"),
+			},
+			&cli.DurationFlag{
+				Name:    "backoff",
+				Value:   5 * time.Second,
+				Usage:   "Wait time before the first retry, doubled with every retry",
+				EnvVars: flagSetHelperEnvKey("CLIENT_BACKOFF"),
+			},
+			&cli.DurationFlag{
+				Name:    "backoff.max",
+				Value:   5 * time.Minute,
+				Usage:   "Maximum backoff between retries, a longer Retry-After of the server is honored",
+				EnvVars: flagSetHelperEnvKey("CLIENT_BACKOFF_MAX"),
+			},
+			&cli.StringFlag{
+				Name:    "cache",
+				Usage:   "Directory to cache the last retrieved certificates, used if the api is not available",
+				EnvVars: flagSetHelperEnvKey("CLIENT_CACHE"),
+			},
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "Config file (yaml) with multiple certificates to retrieve, replaces the single certificate flags",
+				EnvVars: flagSetHelperEnvKey("CLIENT_CONFIG"),
+			},
+			&cli.DurationFlag{
+				Name:    "watch",
+				Usage:   "Keep running and check the certificates in this interval (e.g. 12h)",
+				EnvVars: flagSetHelperEnvKey("CLIENT_WATCH"),
+			},
+			&cli.StringFlag{
+				Name:    "domain",
+				Usage:   "Domain (common name) to obtain cert for, wildcard is allowed to use here",
+				EnvVars: flagSetHelperEnvKey("CLIENT_DOMAIN"),
+			},
+			&cli.BoolFlag{
+				Name:    "onlycn",
+				Usage:   "Retrieve only certs where the common name is matching the domain",
+				EnvVars: flagSetHelperEnvKey("CLIENT_ONLYCN"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "san",
+				Usage:   "Additonal subject alternative names (domains) the cert must have",
+				EnvVars: flagSetHelperEnvKey("CLIENT_SAN"),
+			},
+			&cli.IntFlag{
+				Name:    "valid",
+				Usage:   " How long needs the cert to be valid in days before requesting a new on",
+				EnvVars: flagSetHelperEnvKey("CLIENT_VALID"),
+			},
+			&cli.StringFlag{
+				Name:    "file.cert",
+				Usage:   "Write certificate to file",
+				EnvVars: flagSetHelperEnvKey("CLIENT_FILE_CERT"),
+			},
+			&cli.StringFlag{
+				Name:    "file.ca",
+				Usage:   "Write ca issuer to file",
+				EnvVars: flagSetHelperEnvKey("CLIENT_FILE_CA"),
+			},
+			&cli.StringFlag{
+				Name:    "file.key",
+				Usage:   "Write private key to file",
+				EnvVars: flagSetHelperEnvKey("CLIENT_FILE_KEY"),
+			},
+			&cli.StringFlag{
+				Name:    "file.bundle",
+				Usage:   "Write bundle (cert+ca) to file",
+				EnvVars: flagSetHelperEnvKey("CLIENT_FILE_BUNDLE"),
+			},
+			&cli.BoolFlag{
+				Name:    "reuse-key",
+				Usage:   "Ask the server to reuse the private key on renewal, the server default is used if not set",
+				EnvVars: flagSetHelperEnvKey("CLIENT_REUSE_KEY"),
+			},
+			&cli.StringFlag{
+				Name:    "csr",
+				Usage:   "Submit this pem encoded certificate signing request, the private key is not retrieved from the api",
+				EnvVars: flagSetHelperEnvKey("CLIENT_CSR"),
+			},
+			&cli.BoolFlag{
+				Name:    "generate-key",
+				Usage:   "Generate the private key (file.key) locally if it does not exist and submit a certificate signing request",
+				EnvVars: flagSetHelperEnvKey("CLIENT_GENERATE_KEY"),
+			},
+			&cli.StringFlag{
+				Name:    "kubernetes.secret",
+				Usage:   "Write the certificate to this kubernetes.io/tls secret (in cluster only)",
+				EnvVars: flagSetHelperEnvKey("CLIENT_KUBERNETES_SECRET"),
+			},
+			&cli.StringFlag{
+				Name:    "kubernetes.namespace",
+				Usage:   "Namespace of the kubernetes secrets, defaults to the namespace of the pod",
+				EnvVars: flagSetHelperEnvKey("CLIENT_KUBERNETES_NAMESPACE"),
+			},
+			&cli.BoolFlag{
+				Name:    "kubernetes.force",
+				Usage:   "Take over an existing kubernetes secret which has not been created by certjunkie",
+				EnvVars: flagSetHelperEnvKey("CLIENT_KUBERNETES_FORCE"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "hook",
+				Usage:   "Shell command to run after the certificate files have been changed",
+				EnvVars: flagSetHelperEnvKey("CLIENT_HOOK"),
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cfg, err := clientConfig(c)
+			if err != nil {
+				return err
+			}
+
+			runner := &client.Runner{
+				Client: &api.Client{
+					Address:    cfg.Address,
+					Failover:   cfg.Failover,
+					Timeout:    c.Duration("timeout"),
+					Retries:    c.Int("retries"),
+					Backoff:    c.Duration("backoff"),
+					MaxBackoff: c.Duration("backoff.max"),
+				},
+			}
+			if dir := c.String("cache"); dir != "" {
+				runner.Cache = &client.Cache{Dir: dir}
+			}
+			if cfg.UsesSecrets() {
+				runner.Secrets, err = client.NewInClusterSecretWriter(c.String("kubernetes.namespace"))
+				if err != nil {
+					return fmt.Errorf("cannot initialize kubernetes client: %v", err)
+				}
+			}
+
+			interval := c.Duration("watch")
+			if interval <= 0 {
+				results := runner.Run(cfg)
+				if err := client.HardFailure(results); err != nil {
+					return err
+				}
+				if client.Offline(results) {
+					return cli.Exit("api is not available, certificates have been served from cache", exitOffline)
+				}
+				return nil
+			}
+
+			// watch mode: failures are reported but we keep on running
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				if err := client.HardFailure(runner.Run(cfg)); err != nil {
+					log.Err(err).Msg("certificate run failed")
+				}
+				select {
+				case <-sigs:
+					return nil
+				case <-ticker.C:
+				}
+			}
+		},
+	}
+}
+
+func clientCheckCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "check",
+		Usage:       "check local certificate files (nagios compatible exit codes)",
+		Description: "validates the local certificate files without contacting the api",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "file.cert",
+				Usage: "Certificate (or bundle) file to check",
+			},
+			&cli.StringFlag{
+				Name:  "file.key",
+				Usage: "Private key file which needs to match the certificate",
+			},
+			&cli.StringFlag{
+				Name:  "file.ca",
+				Usage: "CA file the certificate chain is verified against",
+			},
+			&cli.StringFlag{
+				Name:  "domain",
+				Usage: "Domain the certificate needs to be valid for",
+			},
+			&cli.StringSliceFlag{
+				Name:  "san",
+				Usage: "Additional domains the certificate needs to be valid for",
+			},
+			&cli.IntFlag{
+				Name:  "valid",
+				Value: 14,
+				Usage: "Warn if the certificate expires within this number of days",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the result as json",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			if c.String("file.cert") == "" {
+				return cli.Exit("file.cert is not set", int(client.StatusUnknown))
+			}
+
+			result := client.Check(&client.CheckOptions{
+				Cert:   c.String("file.cert"),
+				Key:    c.String("file.key"),
+				CA:     c.String("file.ca"),
+				Domain: c.String("domain"),
+				San:    c.StringSlice("san"),
+				Valid:  c.Int("valid"),
+			})
+
+			if c.Bool("json") {
+				if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+					return cli.Exit(err.Error(), int(client.StatusUnknown))
+				}
+			} else {
+				fmt.Println(result)
+			}
+
+			if result.Status != client.StatusOK {
+				return cli.Exit("", int(result.Status))
+			}
+			return nil
+		},
+	}
+}
+
+// clientConfig loads the config file or builds a single certificate config from the flags
+func clientConfig(c *cli.Context) (*client.Config, error) {
+	if path := c.String("config"); path != "" {
+		cfg, err := client.LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		// the flag wins if explicitly set
+		if cfg.Address == "" || c.IsSet("address") {
+			cfg.Address = c.String("address")
+		}
+		if c.IsSet("failover") {
+			cfg.Failover = c.StringSlice("failover")
+		}
+		return cfg, nil
+	}
+
+	domain := c.String("domain")
+	if domain == "" {
+		return nil, errors.New("domain is not set")
+	}
+
+	cfg := &client.Config{
+		Address:  c.String("address"),
+		Failover: c.StringSlice("failover"),
+		Certificates: []*client.Certificate{
+			{
+				Domain: domain,
+				San:    c.StringSlice("san"),
+				OnlyCN: c.Bool("onlycn"),
+				Valid:  c.Int("valid"),
+				Files: client.Files{
+					Cert:   c.String("file.cert"),
+					CA:     c.String("file.ca"),
+					Key:    c.String("file.key"),
+					Bundle: c.String("file.bundle"),
+				},
+				CSR:         c.String("csr"),
+				GenerateKey: c.Bool("generate-key"),
+				Hooks:       c.StringSlice("hook"),
+			},
+		},
+	}
+	if c.IsSet("reuse-key") {
+		reuseKey := c.Bool("reuse-key")
+		cfg.Certificates[0].ReuseKey = &reuseKey
+	}
+	if name := c.String("kubernetes.secret"); name != "" {
+		cfg.Certificates[0].Secret = &client.Secret{Name: name, Force: c.Bool("kubernetes.force")}
+	}
+	return cfg, cfg.Validate()
+}
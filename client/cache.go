@@ -0,0 +1,77 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+// Cache keeps the last successfully retrieved certificate of every request on disk
+type Cache struct {
+	Dir string
+}
+
+// Save stores the certificate for the request
+func (c *Cache) Save(cert *Certificate, res *certstore.CertificateResource) error {
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, a broken cache file is worse than an outdated one
+	path := c.path(cert)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load returns the cached certificate of the request if it still covers all names and is not expired
+func (c *Cache) Load(cert *Certificate) (*certstore.CertificateResource, error) {
+	data, err := os.ReadFile(c.path(cert))
+	if err != nil {
+		return nil, err
+	}
+	res := &certstore.CertificateResource{}
+	if err := json.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+
+	request := &certstore.CertRequest{
+		Domain: cert.Domain,
+		San:    cert.San,
+	}
+	ok, err := request.MatchCertificate(res)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("cached certificate is expired or does not match")
+	}
+	return res, nil
+}
+
+// path returns a cache file unique for the parameters of the request
+func (c *Cache) path(cert *Certificate) string {
+	san := append([]string{}, cert.San...)
+	sort.Strings(san)
+	sum := sha256.Sum256([]byte(strings.Join(append([]string{cert.Domain}, san...), ",")))
+
+	name := strings.ReplaceAll(strings.ToLower(cert.Domain), "*", "_")
+	name = strings.ReplaceAll(name, string(filepath.Separator), "_")
+	if cert.OnlyCN {
+		name += "-cn"
+	}
+	return filepath.Join(c.Dir, name+"-"+hex.EncodeToString(sum[:8])+".json")
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore"
+	"github.com/project0/certjunkie/internal/testcert"
+)
+
+// selfSigned creates a certificate resource for the given names, the first name is the common name
+func selfSigned(t *testing.T, notAfter time.Time, names ...string) *certstore.CertificateResource {
+	cert, key := testcert.New(t, time.Now().Add(-time.Hour), notAfter, names...)
+	return &certstore.CertificateResource{
+		Domain:            names[0],
+		Certificate:       cert,
+		IssuerCertificate: cert,
+		PrivateKey:        key,
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	cert := &Certificate{Domain: "example.com", San: []string{"www.example.com"}}
+
+	_, err := cache.Load(cert)
+	assert.Error(t, err)
+
+	res := selfSigned(t, time.Now().Add(24*time.Hour), "example.com", "www.example.com")
+	assert.NoError(t, cache.Save(cert, res))
+
+	cached, err := cache.Load(cert)
+	assert.NoError(t, err)
+	assert.Equal(t, res.Certificate, cached.Certificate)
+	assert.Equal(t, res.PrivateKey, cached.PrivateKey)
+
+	// other request parameters must not share the cache entry
+	_, err = cache.Load(&Certificate{Domain: "example.com"})
+	assert.Error(t, err)
+
+	// expired certificates are not served
+	assert.NoError(t, cache.Save(cert, selfSigned(t, time.Now().Add(-time.Minute), "example.com", "www.example.com")))
+	_, err = cache.Load(cert)
+	assert.Error(t, err)
+}
@@ -0,0 +1,216 @@
+package client
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+// Status of a check, the values are the nagios plugin exit codes
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusWarning
+	StatusCritical
+	StatusUnknown
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "OK"
+	case StatusWarning:
+		return "WARNING"
+	case StatusCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// MarshalJSON encodes the status by name
+func (s Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// CheckOptions describes the local files and the expectations to check
+type CheckOptions struct {
+	Cert string
+	Key  string
+	CA   string
+	// Domain and San are the names the cert needs to be valid for, optional
+	Domain string
+	San    []string
+	// Valid is the number of days the cert needs to be valid, otherwise it is a warning
+	Valid int
+}
+
+// CheckItem is the result of a single check
+type CheckItem struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+}
+
+// CheckResult contains the overall status and the result of every check
+type CheckResult struct {
+	Status   Status      `json:"status"`
+	NotAfter *time.Time  `json:"not_after,omitempty"`
+	DaysLeft int         `json:"days_left"`
+	Checks   []CheckItem `json:"checks"`
+}
+
+func (r *CheckResult) add(name string, status Status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, CheckItem{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+	if status > r.Status {
+		r.Status = status
+	}
+}
+
+// String returns a nagios plugin compatible output
+func (r *CheckResult) String() string {
+	lines := []string{}
+	summary := []string{}
+	for _, item := range r.Checks {
+		lines = append(lines, fmt.Sprintf("%s: %s - %s", item.Name, item.Status, item.Message))
+		if item.Status != StatusOK {
+			summary = append(summary, item.Message)
+		}
+	}
+	if len(summary) == 0 && r.NotAfter != nil {
+		summary = append(summary, fmt.Sprintf("certificate is valid until %s (%d days)", r.NotAfter.Format(time.RFC3339), r.DaysLeft))
+	}
+	return fmt.Sprintf("CERTJUNKIE %s - %s\n%s", r.Status, strings.Join(summary, ", "), strings.Join(lines, "\n"))
+}
+
+// Check validates the local certificate files without contacting the api
+func Check(opts *CheckOptions) *CheckResult {
+	result := &CheckResult{Checks: []CheckItem{}}
+
+	certs, err := readCertificates(opts.Cert)
+	if err != nil {
+		result.add("certificate", StatusCritical, "cannot read certificate: %v", err)
+		return result
+	}
+	leaf := certs[0]
+	result.add("certificate", StatusOK, "serial %s issued by %s", leaf.SerialNumber, leaf.Issuer.CommonName)
+
+	if opts.Key != "" {
+		if err := checkKey(leaf, opts.Key); err != nil {
+			result.add("key", StatusCritical, "%v", err)
+		} else {
+			result.add("key", StatusOK, "private key matches the certificate")
+		}
+	}
+
+	if opts.CA != "" {
+		if err := checkChain(certs, opts.CA); err != nil {
+			result.add("chain", StatusCritical, "cannot verify chain: %v", err)
+		} else {
+			result.add("chain", StatusOK, "certificate is signed by the ca")
+		}
+	}
+
+	if opts.Domain != "" {
+		request := &certstore.CertRequest{Domain: opts.Domain, San: opts.San}
+		if missing := request.MissingHostnames(leaf); len(missing) > 0 {
+			result.add("hostnames", StatusCritical, "certificate is not valid for %s", strings.Join(missing, ","))
+		} else {
+			result.add("hostnames", StatusOK, "certificate is valid for all requested names")
+		}
+	}
+
+	notAfter := leaf.NotAfter
+	result.NotAfter = &notAfter
+	result.DaysLeft = int(time.Until(notAfter).Hours() / 24)
+	switch {
+	case time.Now().After(notAfter):
+		result.add("expiry", StatusCritical, "certificate expired at %s", notAfter.Format(time.RFC3339))
+	case time.Now().Add(time.Duration(opts.Valid) * 24 * time.Hour).After(notAfter):
+		result.add("expiry", StatusWarning, "certificate expires in %d days at %s", result.DaysLeft, notAfter.Format(time.RFC3339))
+	default:
+		result.add("expiry", StatusOK, "certificate expires in %d days at %s", result.DaysLeft, notAfter.Format(time.RFC3339))
+	}
+
+	return result
+}
+
+// readCertificates parses all pem encoded certificates of the file, the first one is the leaf
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no pem encoded certificate found")
+	}
+	return certs, nil
+}
+
+func checkKey(cert *x509.Certificate, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read private key: %v", err)
+	}
+	signer, err := parsePrivateKey(data)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return errors.New("private key does not match the certificate")
+	}
+	return nil
+}
+
+// checkChain verifies the leaf with the certificates of the ca file as trusted roots,
+// additional certificates of the cert file (bundle) are used as intermediates.
+func checkChain(certs []*x509.Certificate, path string) error {
+	ca, err := readCertificates(path)
+	if err != nil {
+		return err
+	}
+
+	roots := x509.NewCertPool()
+	for _, cert := range ca {
+		roots.AddCert(cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
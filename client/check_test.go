@@ -0,0 +1,67 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+func writeCheckFiles(t *testing.T, res *certstore.CertificateResource) *CheckOptions {
+	dir := t.TempDir()
+	opts := &CheckOptions{
+		Cert: filepath.Join(dir, "tls.crt"),
+		Key:  filepath.Join(dir, "tls.key"),
+		CA:   filepath.Join(dir, "ca.crt"),
+	}
+	assert.NoError(t, os.WriteFile(opts.Cert, res.Certificate, 0600))
+	assert.NoError(t, os.WriteFile(opts.Key, res.PrivateKey, 0600))
+	assert.NoError(t, os.WriteFile(opts.CA, res.IssuerCertificate, 0600))
+	return opts
+}
+
+func TestCheck(t *testing.T) {
+	opts := writeCheckFiles(t, selfSigned(t, time.Now().Add(30*24*time.Hour), "example.com", "www.example.com"))
+	opts.Domain = "example.com"
+	opts.San = []string{"www.example.com"}
+	opts.Valid = 14
+
+	result := Check(opts)
+	assert.Equal(t, StatusOK, result.Status, result.String())
+	assert.Len(t, result.Checks, 5)
+	assert.Equal(t, 29, result.DaysLeft)
+
+	// threshold reached
+	opts.Valid = 60
+	assert.Equal(t, StatusWarning, Check(opts).Status)
+
+	// name not covered
+	opts.Valid = 14
+	opts.San = []string{"api.example.com"}
+	assert.Equal(t, StatusCritical, Check(opts).Status)
+}
+
+func TestCheckKeyMismatch(t *testing.T) {
+	opts := writeCheckFiles(t, selfSigned(t, time.Now().Add(30*24*time.Hour), "example.com"))
+	other := selfSigned(t, time.Now().Add(30*24*time.Hour), "example.com")
+	assert.NoError(t, os.WriteFile(opts.Key, other.PrivateKey, 0600))
+
+	result := Check(opts)
+	assert.Equal(t, StatusCritical, result.Status)
+	assert.Equal(t, "key", result.Checks[1].Name)
+	assert.Equal(t, StatusCritical, result.Checks[1].Status)
+
+	// chain does not verify against another ca
+	assert.NoError(t, os.WriteFile(opts.CA, other.Certificate, 0600))
+	result = Check(opts)
+	assert.Equal(t, StatusCritical, result.Checks[2].Status)
+}
+
+func TestCheckMissingFile(t *testing.T) {
+	result := Check(&CheckOptions{Cert: filepath.Join(t.TempDir(), "missing.crt")})
+	assert.Equal(t, StatusCritical, result.Status)
+}
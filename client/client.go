@@ -0,0 +1,239 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/project0/certjunkie/api"
+	"github.com/project0/certjunkie/certstore"
+)
+
+// Result is the outcome of processing a single certificate
+type Result struct {
+	Certificate *Certificate
+	// Changed is true if at least one file has been (re)written
+	Changed bool
+	// Offline is true if the api was not available and the certificate has been served from the cache
+	Offline bool
+	Err     error
+	// Hard marks failures which are not solved by retrying later (e.g. unwritable files)
+	Hard bool
+}
+
+// Getter retrieves certificates, it is implemented by the api.Client
+type Getter interface {
+	GetRequest(request *certstore.CertRequest) (*certstore.CertificateResource, error)
+	GetForCSR(csr []byte, valid int) (*certstore.CertificateResource, error)
+}
+
+// Runner retrieves the configured certificates and writes them to disk
+type Runner struct {
+	Client Getter
+	// Cache is used as fallback if the api is not available, optional
+	Cache *Cache
+	// Secrets writes certificates to kubernetes secrets, required if any certificate has a secret configured
+	Secrets *SecretWriter
+
+	// pending holds the certificates whose hooks failed, they are run again even if nothing changed
+	pending map[string]bool
+}
+
+// Run processes all certificates of the config, failures of one certificate do not affect others
+func (r *Runner) Run(cfg *Config) []*Result {
+	results := make([]*Result, 0, len(cfg.Certificates))
+	for _, cert := range cfg.Certificates {
+		result := r.process(cert)
+		logger := log.With().Str("domain", cert.Domain).Logger()
+		switch {
+		case result.Err != nil:
+			logger.Err(result.Err).Bool("hard", result.Hard).Msg("failed to process certificate")
+		case result.Changed:
+			logger.Info().Msg("certificate updated")
+		default:
+			logger.Debug().Msg("certificate is up to date")
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (r *Runner) process(cert *Certificate) *Result {
+	result := &Result{Certificate: cert}
+
+	log.Info().
+		Str("domain", cert.Domain).
+		Strs("san", cert.San).
+		Bool("onlycn", cert.OnlyCN).
+		Int("valid", cert.Valid).
+		Msg("request certificate")
+	res, err := r.get(cert)
+	switch {
+	case err == nil:
+		if r.Cache != nil {
+			if err := r.Cache.Save(cert, res); err != nil {
+				log.Warn().Err(err).Str("domain", cert.Domain).Msg("cannot save certificate to cache")
+			}
+		}
+	case r.Cache != nil && errors.Is(err, api.ErrUnavailable):
+		cached, cacheErr := r.Cache.Load(cert)
+		if cacheErr != nil {
+			log.Debug().Err(cacheErr).Str("domain", cert.Domain).Msg("no usable certificate in cache")
+			result.Err = err
+			return result
+		}
+		log.Warn().Err(err).Str("domain", cert.Domain).Msg("api is not available, use last known good certificate from cache")
+		res = cached
+		result.Offline = true
+	default:
+		result.Err = err
+		return result
+	}
+
+	result.Changed, result.Err = writeFiles(res, cert.Files)
+	if result.Err != nil {
+		result.Hard = true
+		return result
+	}
+
+	if cert.Secret != nil {
+		if r.Secrets == nil {
+			result.Err = errors.New("kubernetes secrets are not configured")
+			result.Hard = true
+			return result
+		}
+		changed, err := r.Secrets.Write(context.Background(), *cert.Secret, res)
+		if err != nil {
+			result.Err = fmt.Errorf("cannot write secret %s: %v", cert.Secret.Name, err)
+			return result
+		}
+		result.Changed = result.Changed || changed
+	}
+
+	key := hookKey(cert)
+	if result.Changed || r.pending[key] {
+		result.Err = runHooks(cert)
+		if r.pending == nil {
+			r.pending = map[string]bool{}
+		}
+		if result.Err != nil {
+			r.pending[key] = true
+		} else {
+			delete(r.pending, key)
+		}
+	}
+	return result
+}
+
+// hookKey identifies a certificate of the config across runs
+func hookKey(cert *Certificate) string {
+	key := fmt.Sprintf("%s %v", cert.Domain, cert.Files)
+	if cert.Secret != nil {
+		key += " " + cert.Secret.Namespace + "/" + cert.Secret.Name
+	}
+	return key
+}
+
+// get retrieves the certificate from the api, with a csr if the private key is managed locally
+func (r *Runner) get(cert *Certificate) (*certstore.CertificateResource, error) {
+	if cert.CSR == "" && !cert.GenerateKey {
+		return r.Client.GetRequest(&certstore.CertRequest{
+			Domain:     cert.Domain,
+			DomainIsCn: cert.OnlyCN,
+			ValidDays:  cert.Valid,
+			San:        cert.San,
+			ReuseKey:   cert.ReuseKey,
+		})
+	}
+
+	csr, key, err := loadCSR(cert)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create csr: %v", err)
+	}
+	res, err := r.Client.GetForCSR(csr, cert.Valid)
+	if err != nil {
+		return nil, err
+	}
+	res.PrivateKey = key
+	return res, nil
+}
+
+// Offline returns true if any certificate has been served from the cache
+func Offline(results []*Result) bool {
+	for _, result := range results {
+		if result.Offline {
+			return true
+		}
+	}
+	return false
+}
+
+// HardFailure returns an error if the results contain a failure which should fail the whole run.
+// This is the case for hard failures or when not a single certificate could be processed.
+func HardFailure(results []*Result) error {
+	failed := 0
+	for _, result := range results {
+		if result.Err == nil {
+			continue
+		}
+		if result.Hard {
+			return fmt.Errorf("certificate %s: %v", result.Certificate.Domain, result.Err)
+		}
+		failed++
+	}
+	if len(results) > 0 && failed == len(results) {
+		return errors.New("failed to process any certificate")
+	}
+	return nil
+}
+
+func writeFiles(cert *certstore.CertificateResource, files Files) (changed bool, err error) {
+	outputs := []struct {
+		path string
+		data []byte
+		perm os.FileMode
+	}{
+		{files.Cert, cert.GetNoBundleCertificate(), 0644},
+		{files.CA, cert.IssuerCertificate, 0644},
+		{files.Key, cert.PrivateKey, 0600},
+		{files.Bundle, append(cert.GetNoBundleCertificate(), cert.IssuerCertificate...), 0644},
+	}
+
+	for _, out := range outputs {
+		// the private key is unknown if it is managed outside of certjunkie (csr)
+		if out.path == "" || out.data == nil {
+			continue
+		}
+		written, err := writeFileIfChanged(out.path, out.data, out.perm)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || written
+	}
+	return changed, nil
+}
+
+// writeFileIfChanged writes the file only if the content differs, to avoid unneeded hook executions
+func writeFileIfChanged(path string, data []byte, perm os.FileMode) (bool, error) {
+	current, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+	return true, os.WriteFile(path, data, perm)
+}
+
+func runHooks(cert *Certificate) error {
+	for _, hook := range cert.Hooks {
+		log.Info().Str("domain", cert.Domain).Str("hook", hook).Msg("run hook")
+		out, err := exec.Command("sh", "-c", hook).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("hook %q failed: %v: %s", hook, err, out)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+type fakeGetter struct {
+	cert     *certstore.CertificateResource
+	requests int
+}
+
+func (f *fakeGetter) GetRequest(request *certstore.CertRequest) (*certstore.CertificateResource, error) {
+	f.requests++
+	res := *f.cert
+	return &res, nil
+}
+
+func (f *fakeGetter) GetForCSR(csr []byte, valid int) (*certstore.CertificateResource, error) {
+	f.requests++
+	res := *f.cert
+	return &res, nil
+}
+
+// hookRuns returns how often the hook appended to the file
+func hookRuns(t *testing.T, path string) int {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	assert.NoError(t, err)
+	return strings.Count(string(data), "run\n")
+}
+
+func TestRunnerWritesOnlyChanged(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "hook.log")
+	cert := &Certificate{
+		Domain: "example.com",
+		Files:  Files{Cert: filepath.Join(dir, "cert.pem"), Key: filepath.Join(dir, "key.pem")},
+		Hooks:  []string{"echo run >> " + log},
+	}
+	getter := &fakeGetter{cert: selfSigned(t, time.Now().Add(time.Hour), "example.com")}
+	runner := &Runner{Client: getter}
+	cfg := &Config{Certificates: []*Certificate{cert}}
+
+	results := runner.Run(cfg)
+	assert.NoError(t, results[0].Err)
+	assert.True(t, results[0].Changed)
+	assert.Equal(t, 1, hookRuns(t, log))
+	data, err := os.ReadFile(cert.Files.Cert)
+	assert.NoError(t, err)
+	assert.Equal(t, getter.cert.GetNoBundleCertificate(), data)
+
+	results = runner.Run(cfg)
+	assert.NoError(t, results[0].Err)
+	assert.False(t, results[0].Changed)
+	assert.Equal(t, 1, hookRuns(t, log), "hook must not run without changes")
+
+	getter.cert = selfSigned(t, time.Now().Add(2*time.Hour), "example.com")
+	results = runner.Run(cfg)
+	assert.NoError(t, results[0].Err)
+	assert.True(t, results[0].Changed)
+	assert.Equal(t, 2, hookRuns(t, log))
+	assert.Equal(t, 3, getter.requests)
+}
+
+func TestRunnerRetriesFailedHook(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "hook.log")
+	ready := filepath.Join(dir, "ready")
+	cert := &Certificate{
+		Domain: "example.com",
+		Files:  Files{Bundle: filepath.Join(dir, "bundle.pem")},
+		Hooks:  []string{"test -f " + ready + " && echo run >> " + log},
+	}
+	runner := &Runner{Client: &fakeGetter{cert: selfSigned(t, time.Now().Add(time.Hour), "example.com")}}
+	cfg := &Config{Certificates: []*Certificate{cert}}
+
+	results := runner.Run(cfg)
+	assert.Error(t, results[0].Err)
+	assert.False(t, results[0].Hard)
+	assert.True(t, results[0].Changed)
+
+	// the files are unchanged, but the failed hook has to run again
+	assert.NoError(t, os.WriteFile(ready, nil, 0644))
+	results = runner.Run(cfg)
+	assert.NoError(t, results[0].Err)
+	assert.False(t, results[0].Changed)
+	assert.Equal(t, 1, hookRuns(t, log))
+
+	results = runner.Run(cfg)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 1, hookRuns(t, log), "hook must not run again after success")
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes all certificates a client keeps in sync with a certjunkie api
+type Config struct {
+	Address string `yaml:"address"`
+	// Failover lists additional api addresses used if Address is not available
+	Failover     []string       `yaml:"failover"`
+	Certificates []*Certificate `yaml:"certificates"`
+}
+
+// Certificate describes a single certificate request and where to write the result
+type Certificate struct {
+	Domain string   `yaml:"domain"`
+	San    []string `yaml:"san"`
+	OnlyCN bool     `yaml:"onlycn"`
+	Valid  int      `yaml:"valid"`
+	// ReuseKey asks the server to keep the private key on renewal, the server default is used if not set
+	ReuseKey *bool `yaml:"reusekey"`
+	Files    Files `yaml:"files"`
+	// CSR is a pem encoded certificate signing request submitted instead of requesting a server side private key
+	CSR string `yaml:"csr"`
+	// GenerateKey creates a local private key (files.key) and submits a csr for it, the key never leaves the client
+	GenerateKey bool `yaml:"generatekey"`
+	// Secret is the kubernetes secret to write the certificate to, optional
+	Secret *Secret `yaml:"secret"`
+	// Hooks are shell commands executed after any of the files has been changed
+	Hooks []string `yaml:"hooks"`
+}
+
+// Files lists the output files of a certificate, empty paths are not written
+type Files struct {
+	Cert   string `yaml:"cert"`
+	CA     string `yaml:"ca"`
+	Key    string `yaml:"key"`
+	Bundle string `yaml:"bundle"`
+}
+
+// LoadConfig reads and validates a yaml config file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse config %s: %v", path, err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// UsesSecrets returns true if any certificate is written to a kubernetes secret
+func (c *Config) UsesSecrets() bool {
+	for _, cert := range c.Certificates {
+		if cert.Secret != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the config for missing or conflicting settings
+func (c *Config) Validate() error {
+	if len(c.Certificates) == 0 {
+		return errors.New("no certificates configured")
+	}
+
+	files := map[string]string{}
+	for i, cert := range c.Certificates {
+		if cert == nil || cert.Domain == "" {
+			return fmt.Errorf("certificate %d: domain is not set", i)
+		}
+		if cert.CSR != "" && cert.GenerateKey {
+			return fmt.Errorf("certificate %s: csr and generatekey cannot be used together", cert.Domain)
+		}
+		if cert.GenerateKey && cert.Files.Key == "" {
+			return fmt.Errorf("certificate %s: generatekey requires a key file", cert.Domain)
+		}
+		if cert.Secret != nil && cert.Secret.Name == "" {
+			return fmt.Errorf("certificate %s: secret name is not set", cert.Domain)
+		}
+		for _, path := range []string{cert.Files.Cert, cert.Files.CA, cert.Files.Key, cert.Files.Bundle} {
+			if path == "" {
+				continue
+			}
+			if other, ok := files[path]; ok {
+				return fmt.Errorf("certificate %s: file %s is already used by %s", cert.Domain, path, other)
+			}
+			files[path] = cert.Domain
+		}
+	}
+	return nil
+}
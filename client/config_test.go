@@ -0,0 +1,57 @@
+package client
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "certs.yaml")
+	err := os.WriteFile(path, []byte(`
+address: http://certjunkie:8080
+certificates:
+  - domain: example.com
+    san: [www.example.com]
+    valid: 14
+    files:
+      cert: /tmp/example.com.crt
+      key: /tmp/example.com.key
+    hooks:
+      - systemctl reload nginx
+  - domain: "*.example.org"
+    onlycn: true
+`), 0600)
+	assert.NoError(t, err)
+
+	cfg, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://certjunkie:8080", cfg.Address)
+	assert.Len(t, cfg.Certificates, 2)
+	assert.Equal(t, []string{"www.example.com"}, cfg.Certificates[0].San)
+	assert.Equal(t, "/tmp/example.com.key", cfg.Certificates[0].Files.Key)
+	assert.Equal(t, []string{"systemctl reload nginx"}, cfg.Certificates[0].Hooks)
+	assert.True(t, cfg.Certificates[1].OnlyCN)
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.Error(t, (&Config{}).Validate())
+	assert.Error(t, (&Config{Certificates: []*Certificate{{}}}).Validate())
+	assert.Error(t, (&Config{Certificates: []*Certificate{
+		{Domain: "a.example.com", Files: Files{Cert: "/tmp/a.crt"}},
+		{Domain: "b.example.com", Files: Files{Bundle: "/tmp/a.crt"}},
+	}}).Validate())
+	assert.NoError(t, (&Config{Certificates: []*Certificate{{Domain: "example.com"}}}).Validate())
+}
+
+func TestHardFailure(t *testing.T) {
+	a := &Certificate{Domain: "a.example.com"}
+	b := &Certificate{Domain: "b.example.com"}
+
+	assert.NoError(t, HardFailure([]*Result{{Certificate: a}, {Certificate: b, Err: errors.New("unavailable")}}))
+	assert.Error(t, HardFailure([]*Result{{Certificate: a, Err: errors.New("unavailable")}, {Certificate: b, Err: errors.New("unavailable")}}))
+	assert.Error(t, HardFailure([]*Result{{Certificate: a}, {Certificate: b, Err: errors.New("permission denied"), Hard: true}}))
+}
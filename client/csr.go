@@ -0,0 +1,102 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// loadCSR returns the pem encoded csr of the certificate and the local private key (if known)
+func loadCSR(cert *Certificate) (csr []byte, key []byte, err error) {
+	if cert.CSR != "" {
+		csr, err = os.ReadFile(cert.CSR)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the key is optional, it is just passed through to the secret and cache
+		if cert.Files.Key != "" {
+			key, _ = os.ReadFile(cert.Files.Key)
+		}
+		return csr, key, nil
+	}
+
+	key, err = loadOrGenerateKey(cert.Files.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := parsePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cert.Domain},
+		DNSNames: append([]string{cert.Domain}, cert.San...),
+	}, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), key, nil
+}
+
+// loadOrGenerateKey reads the private key file, a new key is generated if it does not exist yet
+func loadOrGenerateKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	log.Info().Str("file", path).Msg("generate new private key")
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	key = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return key, os.WriteFile(path, key, 0600)
+}
+
+// parsePrivateKey decodes a pem encoded PKCS1, EC or PKCS8 private key
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no pem encoded private key found")
+	}
+
+	var (
+		key crypto.PrivateKey
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
@@ -0,0 +1,35 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+func TestLoadCSRGenerateKey(t *testing.T) {
+	cert := &Certificate{
+		Domain:      "example.com",
+		San:         []string{"www.example.com"},
+		GenerateKey: true,
+		Files:       Files{Key: filepath.Join(t.TempDir(), "tls.key")},
+	}
+
+	csr, key, err := loadCSR(cert)
+	assert.NoError(t, err)
+	stored, err := os.ReadFile(cert.Files.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, stored, key)
+
+	parsed, err := certstore.ParseCSR(csr)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, certstore.CSRDomains(parsed))
+
+	// the existing key is reused
+	_, again, err := loadCSR(cert)
+	assert.NoError(t, err)
+	assert.Equal(t, key, again)
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+const (
+	// serviceAccountNamespace contains the namespace of the pod when running in cluster
+	serviceAccountNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	labelManagedBy      = "app.kubernetes.io/managed-by"
+	annotationDomain    = "certjunkie.project0.de/domain"
+	secretCAKey         = "ca.crt"
+	managedByCertjunkie = "certjunkie"
+)
+
+// Secret describes the kubernetes secret a certificate is written to
+type Secret struct {
+	Name string `yaml:"name"`
+	// Namespace defaults to the namespace of the secret writer
+	Namespace string `yaml:"namespace"`
+	// OwnerReferences are set on newly created secrets
+	OwnerReferences []metav1.OwnerReference `yaml:"-"`
+	// Force takes over an existing secret which has not been created by certjunkie
+	Force bool `yaml:"force"`
+}
+
+// SecretWriter writes certificates into kubernetes.io/tls secrets
+type SecretWriter struct {
+	Client kubernetes.Interface
+	// Namespace is used for secrets without namespace
+	Namespace string
+}
+
+// NewInClusterSecretWriter creates a secret writer with the service account credentials of the pod
+func NewInClusterSecretWriter(namespace string) (*SecretWriter, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if namespace == "" {
+		ns, err := os.ReadFile(serviceAccountNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("cannot detect namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+
+	return &SecretWriter{
+		Client:    clientset,
+		Namespace: namespace,
+	}, nil
+}
+
+// Write creates or updates the secret with the certificate, it returns true if the secret has been changed.
+// Other data and metadata of an existing secret are kept, secrets not managed by certjunkie are only updated with force.
+// Without a private key (csr) the key of an existing secret is kept.
+func (s *SecretWriter) Write(ctx context.Context, secret Secret, cert *certstore.CertificateResource) (bool, error) {
+	namespace := secret.Namespace
+	if namespace == "" {
+		namespace = s.Namespace
+	}
+	data := map[string][]byte{
+		corev1.TLSCertKey: append(cert.GetNoBundleCertificate(), cert.IssuerCertificate...),
+		secretCAKey:       cert.IssuerCertificate,
+	}
+
+	secrets := s.Client.CoreV1().Secrets(namespace)
+	current, err := secrets.Get(ctx, secret.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// the key is required for the type
+		data[corev1.TLSPrivateKeyKey] = cert.PrivateKey
+		_, err = secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            secret.Name,
+				Namespace:       namespace,
+				Labels:          map[string]string{labelManagedBy: managedByCertjunkie},
+				Annotations:     map[string]string{annotationDomain: cert.Domain},
+				OwnerReferences: secret.OwnerReferences,
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}, metav1.CreateOptions{})
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if current.Type != corev1.SecretTypeTLS {
+		return false, fmt.Errorf("secret %s/%s has type %s, expected %s", namespace, secret.Name, current.Type, corev1.SecretTypeTLS)
+	}
+	managed := current.Labels[labelManagedBy] == managedByCertjunkie
+	if !managed && !secret.Force {
+		return false, fmt.Errorf("secret %s/%s is not managed by certjunkie, force is required to take it over", namespace, secret.Name)
+	}
+	if len(cert.PrivateKey) > 0 {
+		data[corev1.TLSPrivateKeyKey] = cert.PrivateKey
+	}
+
+	changed := false
+	if current.Data == nil {
+		current.Data = map[string][]byte{}
+	}
+	for key, value := range data {
+		if !bytes.Equal(current.Data[key], value) {
+			current.Data[key] = value
+			changed = true
+		}
+	}
+	if !changed && managed {
+		return false, nil
+	}
+	if current.Labels == nil {
+		current.Labels = map[string]string{}
+	}
+	current.Labels[labelManagedBy] = managedByCertjunkie
+
+	if current.Annotations == nil {
+		current.Annotations = map[string]string{}
+	}
+	current.Annotations[annotationDomain] = cert.Domain
+	_, err = secrets.Update(ctx, current, metav1.UpdateOptions{})
+	return err == nil, err
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretWriter(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewClientset()
+	writer := &SecretWriter{Client: clientset, Namespace: "default"}
+	res := selfSigned(t, time.Now().Add(24*time.Hour), "example.com")
+
+	changed, err := writer.Write(ctx, Secret{Name: "example-tls"}, res)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	secret, err := clientset.CoreV1().Secrets("default").Get(ctx, "example-tls", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	assert.Equal(t, res.PrivateKey, secret.Data[corev1.TLSPrivateKeyKey])
+	assert.Equal(t, res.IssuerCertificate, secret.Data["ca.crt"])
+	assert.Equal(t, append(res.GetNoBundleCertificate(), res.IssuerCertificate...), secret.Data[corev1.TLSCertKey])
+
+	// unchanged content does not update the secret
+	actions := len(clientset.Actions())
+	changed, err = writer.Write(ctx, Secret{Name: "example-tls"}, res)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Len(t, clientset.Actions(), actions+1)
+	assert.Equal(t, "get", clientset.Actions()[actions].GetVerb())
+
+	// renewed certificate
+	changed, err = writer.Write(ctx, Secret{Name: "example-tls"}, selfSigned(t, time.Now().Add(48*time.Hour), "example.com"))
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "update", clientset.Actions()[len(clientset.Actions())-1].GetVerb())
+}
+
+func TestSecretWriterNamespaceAndType(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "default"},
+		Type:       corev1.SecretTypeOpaque,
+	})
+	writer := &SecretWriter{Client: clientset, Namespace: "default"}
+	res := selfSigned(t, time.Now().Add(24*time.Hour), "example.com")
+
+	_, err := writer.Write(ctx, Secret{Name: "opaque"}, res)
+	assert.Error(t, err)
+
+	_, err = writer.Write(ctx, Secret{Name: "example-tls", Namespace: "web"}, res)
+	assert.NoError(t, err)
+	_, err = clientset.CoreV1().Secrets("web").Get(ctx, "example-tls", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestSecretWriterUnmanaged(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "foreign-tls", Namespace: "default", Labels: map[string]string{labelManagedBy: "cert-manager"}},
+		Type:       corev1.SecretTypeTLS,
+	})
+	writer := &SecretWriter{Client: clientset, Namespace: "default"}
+	res := selfSigned(t, time.Now().Add(24*time.Hour), "example.com")
+
+	_, err := writer.Write(ctx, Secret{Name: "foreign-tls"}, res)
+	assert.Error(t, err)
+
+	changed, err := writer.Write(ctx, Secret{Name: "foreign-tls", Force: true}, res)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	secret, _ := clientset.CoreV1().Secrets("default").Get(ctx, "foreign-tls", metav1.GetOptions{})
+	assert.Equal(t, managedByCertjunkie, secret.Labels[labelManagedBy])
+
+	// taken over secrets do not need force anymore
+	_, err = writer.Write(ctx, Secret{Name: "foreign-tls"}, res)
+	assert.NoError(t, err)
+}
+
+func TestSecretWriterKeepsKeyWithoutPrivateKey(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewClientset()
+	writer := &SecretWriter{Client: clientset, Namespace: "default"}
+	res := selfSigned(t, time.Now().Add(24*time.Hour), "example.com")
+	_, err := writer.Write(ctx, Secret{Name: "example-tls"}, res)
+	assert.NoError(t, err)
+
+	renewed := selfSigned(t, time.Now().Add(48*time.Hour), "example.com")
+	renewed.PrivateKey = nil
+	changed, err := writer.Write(ctx, Secret{Name: "example-tls"}, renewed)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	secret, _ := clientset.CoreV1().Secrets("default").Get(ctx, "example-tls", metav1.GetOptions{})
+	assert.Equal(t, res.PrivateKey, secret.Data[corev1.TLSPrivateKeyKey])
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/project0/certjunkie/api"
+	"github.com/project0/certjunkie/controller"
+)
+
+func controllerCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "controller",
+		Usage:       "run kubernetes controller for CertjunkieCertificate resources",
+		Description: "reconciles CertjunkieCertificate resources into kubernetes.io/tls secrets, certificates are issued in-process or retrieved from a certjunkie api",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "address",
+				Usage:   "CertJunkie api address to retrieve the certificates from, issue certificates in-process if not set",
+				EnvVars: flagSetHelperEnvKey("CONTROLLER_ADDRESS"),
+			},
+			&cli.StringFlag{
+				Name:    "namespace",
+				Usage:   "Watch only resources in this namespace, defaults to all namespaces",
+				EnvVars: flagSetHelperEnvKey("CONTROLLER_NAMESPACE"),
+			},
+			&cli.DurationFlag{
+				Name:    "resync",
+				Value:   time.Hour,
+				Usage:   "Reconcile all resources in this interval to renew certificates",
+				EnvVars: flagSetHelperEnvKey("CONTROLLER_RESYNC"),
+			},
+			&cli.StringFlag{
+				Name:    "kubeconfig",
+				Usage:   "Path to a kubeconfig file, the in cluster config is used if not set",
+				EnvVars: []string{"KUBECONFIG"},
+			},
+		}, certStoreFlags()...),
+		Action: func(c *cli.Context) error {
+			var (
+				config *rest.Config
+				err    error
+			)
+			if path := c.String("kubeconfig"); path != "" {
+				config, err = clientcmd.BuildConfigFromFlags("", path)
+			} else {
+				config, err = rest.InClusterConfig()
+			}
+			if err != nil {
+				log.Err(err).Msg("failed to load kubernetes config")
+				return errors.New("cannot initialize controller")
+			}
+			dyn, err := dynamic.NewForConfig(config)
+			if err != nil {
+				return err
+			}
+			kube, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				return err
+			}
+
+			var getter controller.CertificateGetter
+			if address := c.String("address"); address != "" {
+				getter = &controller.APIGetter{
+					Client: &api.Client{
+						Address: address,
+						Timeout: 5 * time.Minute,
+					},
+				}
+			} else {
+				cs, storage, err := newCertStore(c)
+				if err != nil {
+					return errors.New("cannot initialize controller")
+				}
+				defer storage.Close()
+				getter = cs
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			return controller.New(dyn, kube, getter, c.String("namespace"), c.Duration("resync")).Run(ctx)
+		},
+	}
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+const (
+	Group   = "certjunkie.project0.de"
+	Version = "v1alpha1"
+	Kind    = "CertjunkieCertificate"
+
+	// ConditionReady reports if the secret contains a valid certificate
+	ConditionReady = "Ready"
+)
+
+// CertificateResource is the group version resource of the CertjunkieCertificate CRD
+var CertificateResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "certjunkiecertificates"}
+
+// CertificateSpec is the desired state of a CertjunkieCertificate
+type CertificateSpec struct {
+	Domain     string
+	Sans       []string
+	OnlyCN     bool
+	Valid      int
+	SecretName string
+}
+
+// parseSpec reads the spec of the unstructured custom resource
+func parseSpec(obj *unstructured.Unstructured) (*CertificateSpec, error) {
+	spec := &CertificateSpec{}
+	var err error
+
+	if spec.Domain, _, err = unstructured.NestedString(obj.Object, "spec", "domain"); err != nil {
+		return nil, err
+	}
+	if spec.Sans, _, err = unstructured.NestedStringSlice(obj.Object, "spec", "sans"); err != nil {
+		return nil, err
+	}
+	if spec.OnlyCN, _, err = unstructured.NestedBool(obj.Object, "spec", "onlycn"); err != nil {
+		return nil, err
+	}
+	valid, _, err := unstructured.NestedInt64(obj.Object, "spec", "valid")
+	if err != nil {
+		return nil, err
+	}
+	spec.Valid = int(valid)
+	if spec.SecretName, _, err = unstructured.NestedString(obj.Object, "spec", "secretName"); err != nil {
+		return nil, err
+	}
+
+	if spec.Domain == "" {
+		return nil, fmt.Errorf("spec.domain is not set")
+	}
+	if spec.SecretName == "" {
+		return nil, fmt.Errorf("spec.secretName is not set")
+	}
+	return spec, nil
+}
+
+// request converts the spec into a request for the certificate store
+func (s *CertificateSpec) request() *certstore.CertRequest {
+	request := &certstore.CertRequest{
+		Domain:     s.Domain,
+		DomainIsCn: s.OnlyCN,
+		ValidDays:  s.Valid,
+		San:        s.Sans,
+	}
+	// same default as the api
+	if request.ValidDays == 0 {
+		request.ValidDays = 30
+	}
+	return request
+}
+
+// CertificateStatus is the observed state of a CertjunkieCertificate
+type CertificateStatus struct {
+	NotAfter  *time.Time
+	LastError string
+	Ready     bool
+	Reason    string
+	Message   string
+}
+
+// setStatus writes the status into the unstructured object, it returns false if nothing has been changed
+func setStatus(obj *unstructured.Unstructured, status *CertificateStatus) (bool, error) {
+	current, _, err := unstructured.NestedMap(obj.Object, "status")
+	if err != nil {
+		return false, err
+	}
+
+	conditionStatus := metav1.ConditionFalse
+	if status.Ready {
+		conditionStatus = metav1.ConditionTrue
+	}
+	condition := map[string]interface{}{
+		"type":               ConditionReady,
+		"status":             string(conditionStatus),
+		"reason":             status.Reason,
+		"message":            status.Message,
+		"observedGeneration": obj.GetGeneration(),
+		"lastTransitionTime": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	// keep the transition time if the condition has not been changed
+	conditions, _, _ := unstructured.NestedSlice(current, "conditions")
+	for _, c := range conditions {
+		existing, ok := c.(map[string]interface{})
+		if !ok || existing["type"] != ConditionReady {
+			continue
+		}
+		if existing["status"] == condition["status"] {
+			condition["lastTransitionTime"] = existing["lastTransitionTime"]
+		}
+	}
+
+	desired := map[string]interface{}{
+		"conditions": []interface{}{condition},
+	}
+	if status.NotAfter != nil {
+		desired["notAfter"] = status.NotAfter.UTC().Format(time.RFC3339)
+	} else if notAfter, ok := current["notAfter"]; ok {
+		// the secret still contains the previous certificate
+		desired["notAfter"] = notAfter
+	}
+	if status.LastError != "" {
+		desired["lastError"] = status.LastError
+	}
+
+	// round trip through the unstructured helpers to compare with the same types
+	if err := unstructured.SetNestedMap(obj.Object, desired, "status"); err != nil {
+		return false, err
+	}
+	desired, _, _ = unstructured.NestedMap(obj.Object, "status")
+	return !reflect.DeepEqual(current, desired), nil
+}
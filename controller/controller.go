@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/project0/certjunkie/api"
+	"github.com/project0/certjunkie/certstore"
+	"github.com/project0/certjunkie/client"
+)
+
+// CertificateGetter retrieves certificates, it is implemented by the certstore.CertStore
+type CertificateGetter interface {
+	GetCertificate(request *certstore.CertRequest) (*certstore.CertificateResource, error)
+}
+
+// APIGetter retrieves the certificates from a certjunkie api
+type APIGetter struct {
+	Client *api.Client
+}
+
+// GetCertificate implements CertificateGetter
+func (a *APIGetter) GetCertificate(request *certstore.CertRequest) (*certstore.CertificateResource, error) {
+	return a.Client.GetRequest(request)
+}
+
+// Controller reconciles CertjunkieCertificate resources into kubernetes.io/tls secrets
+type Controller struct {
+	dynamic   dynamic.Interface
+	secrets   *client.SecretWriter
+	getter    CertificateGetter
+	namespace string
+	resync    time.Duration
+	queue     workqueue.TypedRateLimitingInterface[string]
+}
+
+// New creates a controller watching the namespace (empty for all namespaces),
+// every resource is reconciled again after the resync period to renew certificates.
+func New(dyn dynamic.Interface, kube kubernetes.Interface, getter CertificateGetter, namespace string, resync time.Duration) *Controller {
+	return &Controller{
+		dynamic:   dyn,
+		secrets:   &client.SecretWriter{Client: kube},
+		getter:    getter,
+		namespace: namespace,
+		resync:    resync,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "certjunkie"},
+		),
+	}
+}
+
+// Run watches the resources and reconciles them until the context is done
+func (c *Controller) Run(ctx context.Context) error {
+	defer c.queue.ShutDown()
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamic, c.resync, c.namespace, nil)
+	informer := factory.ForResource(CertificateResource).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueue,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, okOld := oldObj.(metav1.Object)
+			newMeta, okNew := newObj.(metav1.Object)
+			// status updates do not change the generation, resyncs keep the resource version
+			if okOld && okNew && oldMeta.GetGeneration() == newMeta.GetGeneration() && oldMeta.GetResourceVersion() != newMeta.GetResourceVersion() {
+				return
+			}
+			c.enqueue(newObj)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("cannot sync %s informer", Kind)
+	}
+	log.Info().Str("namespace", c.namespace).Msg("controller started")
+
+	go func() {
+		for c.processNextItem(ctx) {
+		}
+	}()
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Err(err).Msg("cannot get key of object")
+		return
+	}
+	c.queue.Add(key)
+}
+
+func (c *Controller) processNextItem(ctx context.Context) bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err == nil {
+		err = c.Reconcile(ctx, namespace, name)
+	}
+	if err != nil {
+		log.Err(err).Str("key", key).Msg("reconcile failed")
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// Reconcile retrieves the certificate of the resource and writes it into the secret
+func (c *Controller) Reconcile(ctx context.Context, namespace, name string) error {
+	resource := c.dynamic.Resource(CertificateResource).Namespace(namespace)
+	obj, err := resource.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// deleted, the secret is removed by the garbage collector
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger := log.With().Str("namespace", namespace).Str("name", name).Logger()
+
+	spec, err := parseSpec(obj)
+	if err != nil {
+		// do not retry until the resource has been changed
+		logger.Err(err).Msg("invalid spec")
+		return c.updateStatus(ctx, obj, &CertificateStatus{Reason: "InvalidSpec", Message: err.Error(), LastError: err.Error()})
+	}
+
+	cert, err := c.getter.GetCertificate(spec.request())
+	if err != nil {
+		if statusErr := c.updateStatus(ctx, obj, &CertificateStatus{Reason: "IssueFailed", Message: "cannot retrieve certificate", LastError: err.Error()}); statusErr != nil {
+			logger.Err(statusErr).Msg("cannot update status")
+		}
+		return err
+	}
+
+	certInfo, err := cert.ParseCertificate()
+	if err != nil {
+		return err
+	}
+
+	secret := client.Secret{
+		Name:      spec.SecretName,
+		Namespace: namespace,
+		OwnerReferences: []metav1.OwnerReference{
+			*metav1.NewControllerRef(obj, obj.GroupVersionKind()),
+		},
+	}
+	changed, err := c.secrets.Write(ctx, secret, cert)
+	if err != nil {
+		if statusErr := c.updateStatus(ctx, obj, &CertificateStatus{Reason: "SecretFailed", Message: "cannot write secret", LastError: err.Error()}); statusErr != nil {
+			logger.Err(statusErr).Msg("cannot update status")
+		}
+		return err
+	}
+	if changed {
+		logger.Info().Str("secret", spec.SecretName).Time("not_after", certInfo.NotAfter).Msg("secret updated")
+	}
+
+	return c.updateStatus(ctx, obj, &CertificateStatus{
+		Ready:    true,
+		Reason:   "Issued",
+		Message:  fmt.Sprintf("certificate is valid until %s", certInfo.NotAfter.UTC().Format(time.RFC3339)),
+		NotAfter: &certInfo.NotAfter,
+	})
+}
+
+// updateStatus writes the status subresource if it has been changed
+func (c *Controller) updateStatus(ctx context.Context, obj *unstructured.Unstructured, status *CertificateStatus) error {
+	changed, err := setStatus(obj, status)
+	if err != nil || !changed {
+		return err
+	}
+	_, err = c.dynamic.Resource(CertificateResource).Namespace(obj.GetNamespace()).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/project0/certjunkie/certstore"
+	"github.com/project0/certjunkie/internal/testcert"
+)
+
+type fakeGetter struct {
+	t        *testing.T
+	requests []*certstore.CertRequest
+	notAfter time.Time
+	err      error
+}
+
+func (f *fakeGetter) GetCertificate(request *certstore.CertRequest) (*certstore.CertificateResource, error) {
+	f.requests = append(f.requests, request)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	cert, key := testcert.New(f.t, time.Now().Add(-time.Hour), f.notAfter, append([]string{request.Domain}, request.San...)...)
+	return &certstore.CertificateResource{
+		Domain:            request.Domain,
+		Certificate:       cert,
+		IssuerCertificate: cert,
+		PrivateKey:        key,
+	}, nil
+}
+
+func newCertificate(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": Group + "/" + Version,
+		"kind":       Kind,
+		"metadata": map[string]interface{}{
+			"name":       name,
+			"namespace":  "web",
+			"uid":        "1234",
+			"generation": int64(1),
+		},
+		"spec": spec,
+	}}
+	return obj
+}
+
+func newController(getter CertificateGetter, objects ...runtime.Object) (*Controller, *dynamicfake.FakeDynamicClient, *fake.Clientset) {
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		CertificateResource: Kind + "List",
+	}, objects...)
+	kube := fake.NewClientset()
+	return New(dyn, kube, getter, "", time.Hour), dyn, kube
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	getter := &fakeGetter{t: t, notAfter: time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second)}
+	c, dyn, kube := newController(getter, newCertificate("example", map[string]interface{}{
+		"domain":     "example.com",
+		"sans":       []interface{}{"www.example.com"},
+		"valid":      int64(14),
+		"secretName": "example-tls",
+	}))
+
+	assert.NoError(t, c.Reconcile(ctx, "web", "example"))
+	assert.Len(t, getter.requests, 1)
+	assert.Equal(t, &certstore.CertRequest{Domain: "example.com", San: []string{"www.example.com"}, ValidDays: 14}, getter.requests[0])
+
+	secret, err := kube.CoreV1().Secrets("web").Get(ctx, "example-tls", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	assert.Len(t, secret.OwnerReferences, 1)
+	assert.Equal(t, Kind, secret.OwnerReferences[0].Kind)
+
+	obj, err := dyn.Resource(CertificateResource).Namespace("web").Get(ctx, "example", metav1.GetOptions{})
+	assert.NoError(t, err)
+	notAfter, _, _ := unstructured.NestedString(obj.Object, "status", "notAfter")
+	assert.Equal(t, getter.notAfter.UTC().Format(time.RFC3339), notAfter)
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	assert.Len(t, conditions, 1)
+	assert.Equal(t, "True", conditions[0].(map[string]interface{})["status"])
+
+	// nothing changed, no status update
+	actions := len(dyn.Actions())
+	assert.NoError(t, c.Reconcile(ctx, "web", "example"))
+	for _, action := range dyn.Actions()[actions:] {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+
+	// failed renewal keeps the previous expiry and reports the error
+	getter.err = errors.New("acme is down")
+	assert.Error(t, c.Reconcile(ctx, "web", "example"))
+	obj, err = dyn.Resource(CertificateResource).Namespace("web").Get(ctx, "example", metav1.GetOptions{})
+	assert.NoError(t, err)
+	lastError, _, _ := unstructured.NestedString(obj.Object, "status", "lastError")
+	assert.Equal(t, "acme is down", lastError)
+	notAfter, _, _ = unstructured.NestedString(obj.Object, "status", "notAfter")
+	assert.Equal(t, getter.notAfter.UTC().Format(time.RFC3339), notAfter)
+	conditions, _, _ = unstructured.NestedSlice(obj.Object, "status", "conditions")
+	assert.Equal(t, "False", conditions[0].(map[string]interface{})["status"])
+	assert.Equal(t, "IssueFailed", conditions[0].(map[string]interface{})["reason"])
+}
+
+func TestReconcileInvalidSpec(t *testing.T) {
+	ctx := context.Background()
+	getter := &fakeGetter{t: t}
+	c, dyn, _ := newController(getter, newCertificate("invalid", map[string]interface{}{
+		"domain": "example.com",
+	}))
+
+	// invalid specs are not retried
+	assert.NoError(t, c.Reconcile(ctx, "web", "invalid"))
+	assert.Len(t, getter.requests, 0)
+
+	obj, err := dyn.Resource(CertificateResource).Namespace("web").Get(ctx, "invalid", metav1.GetOptions{})
+	assert.NoError(t, err)
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	assert.Equal(t, "InvalidSpec", conditions[0].(map[string]interface{})["reason"])
+
+	// deleted resources are ignored
+	assert.NoError(t, c.Reconcile(ctx, "web", "missing"))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+// gcFlags configure the garbage collection of stored certificates
+func gcFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:    "gc.expired-days",
+			Usage:   "Remove certificates expired for this number of days, 0 keeps them",
+			EnvVars: flagSetHelperEnvKey("GC_EXPIRED_DAYS"),
+		},
+		&cli.IntFlag{
+			Name:    "gc.unused-days",
+			Usage:   "Remove certificates not requested for this number of days, 0 keeps them",
+			EnvVars: flagSetHelperEnvKey("GC_UNUSED_DAYS"),
+		},
+		&cli.BoolFlag{
+			Name:    "gc.archive",
+			Usage:   "Move the removed certificates to the history instead of deleting them",
+			EnvVars: flagSetHelperEnvKey("GC_ARCHIVE"),
+		},
+		&cli.BoolFlag{
+			Name:    "gc.dry-run",
+			Usage:   "Only log the certificates which would be removed",
+			EnvVars: flagSetHelperEnvKey("GC_DRY_RUN"),
+		},
+	}
+}
+
+// gcPolicy reads the garbage collection flags
+func gcPolicy(c *cli.Context) (certstore.GCPolicy, error) {
+	policy := certstore.GCPolicy{
+		ExpiredFor: time.Duration(c.Int("gc.expired-days")) * 24 * time.Hour,
+		UnusedFor:  time.Duration(c.Int("gc.unused-days")) * 24 * time.Hour,
+		Archive:    c.Bool("gc.archive"),
+		DryRun:     c.Bool("gc.dry-run"),
+	}
+	if policy.ExpiredFor <= 0 && policy.UnusedFor <= 0 {
+		return policy, errors.New("gc.expired-days or gc.unused-days needs to be set")
+	}
+	return policy, nil
+}
+
+// startGarbageCollection runs the garbage collection of the server if an interval is configured
+func startGarbageCollection(c *cli.Context, certStore *certstore.CertStore) error {
+	if c.Duration("gc.interval") <= 0 {
+		return nil
+	}
+	policy, err := gcPolicy(c)
+	if err != nil {
+		return err
+	}
+	log.Info().Dur("interval", c.Duration("gc.interval")).Msg("start garbage collection of stored certificates")
+	certStore.StartGarbageCollection(policy, c.Duration("gc.interval"))
+	return nil
+}
+
+// gcCommand runs the garbage collection once
+func gcCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "gc",
+		Usage: "remove expired and unused certificates",
+		Flags: append(append(gcFlags(), historyRetentionFlag()), storageFlags()...),
+		Action: func(c *cli.Context) error {
+			policy, err := gcPolicy(c)
+			if err != nil {
+				return err
+			}
+			storage, err := newStorage(c)
+			if err != nil {
+				return err
+			}
+			defer storage.Close()
+
+			results, err := certstore.CollectGarbage(storage, policy, c.Int("history.retention"))
+			for _, result := range results {
+				fmt.Printf("%s\t%s\t%s\t%s\n", result.Key, result.NotAfter.Format(time.RFC3339), result.LastAccessed.Format(time.RFC3339), result.Reason)
+			}
+			log.Info().Int("certificates", len(results)).Bool("dry_run", policy.DryRun).Msg("garbage collection finished")
+			return err
+		},
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"github.com/project0/certjunkie/certstore"
+)
+
+// importCommand writes existing certificates to the storage
+func importCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "import",
+		Usage:       "import existing certificates into the storage",
+		Description: "imports a certificate with its private key or all certificates of a certbot live directory, they are served until they need to be renewed",
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:  "cert",
+				Usage: "Certificate file, may contain the chain (fullchain.pem)",
+			},
+			&cli.StringFlag{
+				Name:  "key",
+				Usage: "Private key file of the certificate",
+			},
+			&cli.StringFlag{
+				Name:  "chain",
+				Usage: "Chain file of the issuer certificates",
+			},
+			&cli.StringFlag{
+				Name:  "certbot",
+				Usage: "Import all certificates of the certbot live directory, e.g. /etc/letsencrypt/live",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Replace stored certificates even if they are valid longer",
+			},
+			historyRetentionFlag(),
+		}, storageFlags()...),
+		Action: func(c *cli.Context) error {
+			if (c.String("cert") == "") == (c.String("certbot") == "") {
+				return errors.New("either cert and key or certbot needs to be set")
+			}
+			if c.String("cert") != "" && c.String("key") == "" {
+				return errors.New("key is not set")
+			}
+
+			storage, err := newStorage(c)
+			if err != nil {
+				return err
+			}
+			defer storage.Close()
+
+			if c.String("cert") != "" {
+				cert, err := certstore.ImportFiles(c.String("cert"), c.String("key"), c.String("chain"))
+				if err != nil {
+					return err
+				}
+				err = certstore.Import(storage, cert, c.Bool("force"), c.Int("history.retention"))
+				if err == certstore.ErrImportSkipped {
+					log.Warn().Str("domain", cert.Domain).Msg("certificate not imported, the stored one is the same or valid longer")
+					return nil
+				}
+				return err
+			}
+
+			lineages, err := certstore.CertbotLineages(c.String("certbot"))
+			if err != nil {
+				return err
+			}
+			imported, skipped, failed := 0, 0, 0
+			for _, dir := range lineages {
+				cert, err := certstore.ImportCertbotLineage(dir)
+				if err == nil {
+					err = certstore.Import(storage, cert, c.Bool("force"), c.Int("history.retention"))
+				}
+				switch {
+				case err == certstore.ErrImportSkipped:
+					log.Info().Str("dir", dir).Msg("skip certificate, the stored one is the same or valid longer")
+					skipped++
+				case err != nil:
+					log.Err(err).Str("dir", dir).Msg("cannot import certificate")
+					failed++
+				default:
+					imported++
+				}
+			}
+			log.Info().Int("imported", imported).Int("skipped", skipped).Int("failed", failed).Msg("certbot import finished")
+			if failed > 0 {
+				return fmt.Errorf("%d certificates could not be imported", failed)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/consul"
+	"github.com/docker/libkv/store/etcd"
+	"github.com/docker/libkv/store/zookeeper"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+
+	"github.com/project0/certjunkie/certstore"
+	kv "github.com/project0/certjunkie/certstore/libkv"
+	"github.com/project0/certjunkie/certstore/libkv/bolt"
+	"github.com/project0/certjunkie/certstore/libkv/kubernetes"
+	"github.com/project0/certjunkie/certstore/libkv/local"
+	"github.com/project0/certjunkie/certstore/libkv/s3"
+	"github.com/project0/certjunkie/certstore/libkv/vault"
+)
+
+// storageFlags are the flags to configure the storage backend
+func storageFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "storage",
+			Value:   "local",
+			Usage:   "Storage driver to use (local, bolt, vault, s3, kubernetes, consul, etcd, zk)",
+			EnvVars: flagSetHelperEnvKey("STORAGE"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.path",
+			Value:   os.Getenv("HOME") + "/.certjunkie",
+			Usage:   "Path to store the certs and account data for the local and bolt storage drivers",
+			EnvVars: flagSetHelperEnvKey("STORAGE_PATH"),
+		},
+		&cli.StringSliceFlag{
+			Name:    "storage.endpoints",
+			Usage:   "Endpoints (host:port) of the consul, etcd and zk storage drivers",
+			EnvVars: flagSetHelperEnvKey("STORAGE_ENDPOINTS"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.prefix",
+			Value:   "certjunkie",
+			Usage:   "Key prefix for the kubernetes, consul, etcd and zk storage drivers",
+			EnvVars: flagSetHelperEnvKey("STORAGE_PREFIX"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.username",
+			Usage:   "Username for the etcd storage driver",
+			EnvVars: flagSetHelperEnvKey("STORAGE_USERNAME"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.password",
+			Usage:   "Password for the etcd storage driver",
+			EnvVars: flagSetHelperEnvKey("STORAGE_PASSWORD"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.tls.ca",
+			Usage:   "CA certificate to verify the consul and etcd servers",
+			EnvVars: flagSetHelperEnvKey("STORAGE_TLS_CA"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.tls.cert",
+			Usage:   "Client certificate for the consul and etcd storage drivers",
+			EnvVars: flagSetHelperEnvKey("STORAGE_TLS_CERT"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.tls.key",
+			Usage:   "Client certificate key for the consul and etcd storage drivers",
+			EnvVars: flagSetHelperEnvKey("STORAGE_TLS_KEY"),
+		},
+		&cli.DurationFlag{
+			Name:    "storage.timeout",
+			Value:   10 * time.Second,
+			Usage:   "Connection timeout for the consul, etcd and zk storage drivers, request timeout for vault and s3, lock timeout for the bolt database",
+			EnvVars: flagSetHelperEnvKey("STORAGE_TIMEOUT"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.vault.address",
+			Usage:   "Address of the vault server for the vault storage driver, defaults to VAULT_ADDR",
+			EnvVars: flagSetHelperEnvKey("STORAGE_VAULT_ADDRESS"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.vault.token",
+			Usage:   "Token for the vault storage driver, defaults to VAULT_TOKEN",
+			EnvVars: flagSetHelperEnvKey("STORAGE_VAULT_TOKEN"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.vault.path",
+			Value:   "secret/certjunkie",
+			Usage:   "KV version 2 mount followed by the path to store the certs and account data for the vault storage driver",
+			EnvVars: flagSetHelperEnvKey("STORAGE_VAULT_PATH"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.s3.bucket",
+			Usage:   "Bucket followed by an optional key prefix (bucket/prefix) for the s3 storage driver",
+			EnvVars: flagSetHelperEnvKey("STORAGE_S3_BUCKET"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.s3.endpoint",
+			Usage:   "Endpoint of a S3 compatible storage (MinIO, Ceph), uses path style addressing. Empty for AWS",
+			EnvVars: flagSetHelperEnvKey("STORAGE_S3_ENDPOINT"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.s3.region",
+			Usage:   "Region of the bucket, defaults to the aws configuration",
+			EnvVars: flagSetHelperEnvKey("STORAGE_S3_REGION"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.s3.access-key",
+			Usage:   "Access key for the s3 storage driver, the default aws credential chain is used if not set",
+			EnvVars: flagSetHelperEnvKey("STORAGE_S3_ACCESS_KEY"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.s3.secret-key",
+			Usage:   "Secret key for the s3 storage driver",
+			EnvVars: flagSetHelperEnvKey("STORAGE_S3_SECRET_KEY"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.s3.sse",
+			Usage:   "Server side encryption of the objects (AES256, aws:kms), the bucket default is used if not set",
+			EnvVars: flagSetHelperEnvKey("STORAGE_S3_SSE"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.s3.kms-key-id",
+			Usage:   "KMS key for aws:kms server side encryption",
+			EnvVars: flagSetHelperEnvKey("STORAGE_S3_KMS_KEY_ID"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.kubernetes.namespace",
+			Usage:   "Namespace of the secrets for the kubernetes storage driver, defaults to the namespace of the pod",
+			EnvVars: flagSetHelperEnvKey("STORAGE_KUBERNETES_NAMESPACE"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.kubernetes.kubeconfig",
+			Usage:   "Path to a kubeconfig for the kubernetes storage driver, the in cluster config is used if not set",
+			EnvVars: flagSetHelperEnvKey("STORAGE_KUBERNETES_KUBECONFIG"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.encryption.key",
+			Usage:   "Encrypt private keys at rest with these keys, format is id:base64(32 bytes) comma separated. The first key encrypts, the others are used to decrypt only",
+			EnvVars: flagSetHelperEnvKey("STORAGE_ENCRYPTION_KEY"),
+		},
+		&cli.StringFlag{
+			Name:    "storage.encryption.key-file",
+			Usage:   "Read the encryption keys from file, one id:base64(32 bytes) per line",
+			EnvVars: flagSetHelperEnvKey("STORAGE_ENCRYPTION_KEY_FILE"),
+		},
+	}
+}
+
+// newKeyring reads the encryption keys from flags, it returns nil if encryption is not configured
+func newKeyring(c *cli.Context) (*certstore.Keyring, error) {
+	switch {
+	case c.String("storage.encryption.key") != "" && c.String("storage.encryption.key-file") != "":
+		return nil, errors.New("storage.encryption.key and storage.encryption.key-file are mutually exclusive")
+	case c.String("storage.encryption.key") != "":
+		return certstore.ParseKeyring(c.String("storage.encryption.key"))
+	case c.String("storage.encryption.key-file") != "":
+		return certstore.LoadKeyring(c.String("storage.encryption.key-file"))
+	}
+	return nil, nil
+}
+
+// storageSpec describes how to connect to a storage backend
+type storageSpec struct {
+	Backend   store.Backend
+	Endpoints []string
+	Config    *store.Config
+	// Prefix of all keys
+	Prefix string
+
+	S3Region   string
+	S3SSE      string
+	S3KMSKeyID string
+}
+
+// storageSpecFromFlags reads the storage flags
+func storageSpecFromFlags(c *cli.Context) (*storageSpec, error) {
+	spec := &storageSpec{
+		Backend:   store.Backend(c.String("storage")),
+		Endpoints: []string{},
+		Config: &store.Config{
+			Bucket: c.String("storage.path"),
+		},
+	}
+	switch spec.Backend {
+	case bolt.BOLT:
+		spec.Endpoints = []string{filepath.Join(c.String("storage.path"), "certjunkie.db")}
+		spec.Config = &store.Config{
+			ConnectionTimeout: c.Duration("storage.timeout"),
+		}
+	case kubernetes.KUBERNETES:
+		spec.Endpoints = []string{c.String("storage.kubernetes.kubeconfig")}
+		spec.Prefix = c.String("storage.prefix")
+		spec.Config = &store.Config{
+			Bucket:            c.String("storage.kubernetes.namespace"),
+			ConnectionTimeout: c.Duration("storage.timeout"),
+		}
+	case store.CONSUL, store.ETCD, store.ZK:
+		spec.Endpoints = c.StringSlice("storage.endpoints")
+		if len(spec.Endpoints) == 0 {
+			return nil, errors.New("storage.endpoints is not set")
+		}
+		spec.Prefix = c.String("storage.prefix")
+		spec.Config = &store.Config{
+			ConnectionTimeout: c.Duration("storage.timeout"),
+			Username:          c.String("storage.username"),
+			Password:          c.String("storage.password"),
+		}
+		tlsConfig, err := kv.TLSConfig(c.String("storage.tls.ca"), c.String("storage.tls.cert"), c.String("storage.tls.key"))
+		if err != nil {
+			return nil, err
+		}
+		spec.Config.TLS = tlsConfig
+	case vault.VAULT:
+		spec.Endpoints = []string{c.String("storage.vault.address")}
+		spec.Config = &store.Config{
+			Bucket:            c.String("storage.vault.path"),
+			Password:          c.String("storage.vault.token"),
+			ConnectionTimeout: c.Duration("storage.timeout"),
+		}
+	case s3.S3:
+		spec.Endpoints = []string{c.String("storage.s3.endpoint")}
+		spec.Config = &store.Config{
+			Bucket:            c.String("storage.s3.bucket"),
+			Username:          c.String("storage.s3.access-key"),
+			Password:          c.String("storage.s3.secret-key"),
+			ConnectionTimeout: c.Duration("storage.timeout"),
+		}
+		spec.S3Region = c.String("storage.s3.region")
+		spec.S3SSE = c.String("storage.s3.sse")
+		spec.S3KMSKeyID = c.String("storage.s3.kms-key-id")
+	}
+	return spec, nil
+}
+
+// parseStorageSpec reads a storage in the format backend:target?option=value, the target is
+//
+//	local:<path>, bolt:<path>
+//	vault:<mount/path>?address=&token=
+//	s3:<bucket/prefix>?endpoint=&region=&access-key=&secret-key=&sse=&kms-key-id=
+//	kubernetes:<namespace>?kubeconfig=&prefix=
+//	consul|etcd|zk:<endpoint,endpoint>[/prefix]?username=&password=&tls-ca=&tls-cert=&tls-key=
+func parseStorageSpec(value string) (*storageSpec, error) {
+	backend, target, ok := strings.Cut(value, ":")
+	if !ok || backend == "" {
+		return nil, fmt.Errorf("invalid storage %q, expected backend:target", value)
+	}
+	target, rawQuery, _ := strings.Cut(target, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage options %q: %v", rawQuery, err)
+	}
+
+	spec := &storageSpec{
+		Backend:   store.Backend(backend),
+		Endpoints: []string{},
+		Config:    &store.Config{ConnectionTimeout: 10 * time.Second},
+	}
+	switch spec.Backend {
+	case local.LOCAL:
+		spec.Config.Bucket = target
+	case bolt.BOLT:
+		spec.Endpoints = []string{filepath.Join(target, "certjunkie.db")}
+	case kubernetes.KUBERNETES:
+		spec.Endpoints = []string{query.Get("kubeconfig")}
+		spec.Config.Bucket = target
+		spec.Prefix = "certjunkie"
+		if query.Has("prefix") {
+			spec.Prefix = query.Get("prefix")
+		}
+	case store.CONSUL, store.ETCD, store.ZK:
+		endpoints, prefix, found := strings.Cut(target, "/")
+		if endpoints == "" {
+			return nil, fmt.Errorf("storage %s has no endpoints", backend)
+		}
+		spec.Endpoints = strings.Split(endpoints, ",")
+		spec.Prefix = "certjunkie"
+		if found {
+			spec.Prefix = prefix
+		}
+		spec.Config.Username = query.Get("username")
+		spec.Config.Password = query.Get("password")
+		if spec.Config.TLS, err = kv.TLSConfig(query.Get("tls-ca"), query.Get("tls-cert"), query.Get("tls-key")); err != nil {
+			return nil, err
+		}
+	case vault.VAULT:
+		spec.Endpoints = []string{query.Get("address")}
+		spec.Config.Bucket = target
+		spec.Config.Password = query.Get("token")
+	case s3.S3:
+		spec.Endpoints = []string{query.Get("endpoint")}
+		spec.Config.Bucket = target
+		spec.Config.Username = query.Get("access-key")
+		spec.Config.Password = query.Get("secret-key")
+		spec.S3Region = query.Get("region")
+		spec.S3SSE = query.Get("sse")
+		spec.S3KMSKeyID = query.Get("kms-key-id")
+	default:
+		return nil, fmt.Errorf("unknown storage backend %s", backend)
+	}
+	return spec, nil
+}
+
+// openStorage initializes the storage backend, wrapped with encryption if a keyring is given
+func openStorage(spec *storageSpec, keyring *certstore.Keyring) (store.Store, error) {
+	local.Register()
+	bolt.Register()
+	vault.Register()
+	s3.Register()
+	kubernetes.Register()
+	consul.Register()
+	etcd.Register()
+	zookeeper.Register()
+
+	storage, err := libkv.NewStore(spec.Backend, spec.Endpoints, spec.Config)
+	if err != nil {
+		return nil, err
+	}
+	if objectStore, ok := storage.(*s3.ObjectStore); ok {
+		objectStore.Region = spec.S3Region
+		objectStore.ServerSideEncryption = spec.S3SSE
+		objectStore.KMSKeyID = spec.S3KMSKeyID
+	}
+	storage = kv.NewPrefix(storage, spec.Prefix)
+
+	if keyring != nil {
+		return certstore.NewEncryptedStore(storage, keyring), nil
+	}
+	return storage, nil
+}
+
+// newStorage initializes the storage configured by flags
+func newStorage(c *cli.Context) (store.Store, error) {
+	spec, err := storageSpecFromFlags(c)
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := newKeyring(c)
+	if err != nil {
+		return nil, err
+	}
+	return openStorage(spec, keyring)
+}
+
+// storageCommand contains maintenance tasks for the storage
+func storageCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "storage",
+		Usage: "maintain the certificate storage",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "reencrypt",
+				Usage: "encrypt all private keys with the first encryption key, run it after adding a new key or enabling encryption",
+				Flags: storageFlags(),
+				Action: func(c *cli.Context) error {
+					storage, err := newStorage(c)
+					if err != nil {
+						return err
+					}
+					defer storage.Close()
+
+					encrypted, ok := storage.(*certstore.EncryptedStore)
+					if !ok {
+						return errors.New("storage encryption is not configured")
+					}
+					count, err := encrypted.Reencrypt()
+					if err != nil {
+						return err
+					}
+					log.Info().Int("records", count).Msg("storage reencrypted")
+					return nil
+				},
+			},
+			{
+				Name:  "history",
+				Usage: "list the archived certificates of a domain",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:     "domain",
+						Usage:    "Domain (common name) of the certificate",
+						Required: true,
+					},
+				}, storageFlags()...),
+				Action: func(c *cli.Context) error {
+					key, err := certstore.CertificateKey(c.String("domain"))
+					if err != nil {
+						return err
+					}
+					storage, err := newStorage(c)
+					if err != nil {
+						return err
+					}
+					defer storage.Close()
+
+					history, err := certstore.History(storage, key)
+					if err != nil {
+						return err
+					}
+					for _, entry := range history {
+						fmt.Printf("%s\t%s\t%s\t%s\n", entry.ID, entry.NotBefore.Format(time.RFC3339), entry.NotAfter.Format(time.RFC3339), entry.Issuer)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "restore",
+				Usage: "replace the stored certificate of a domain with an archived one, running servers without storage watch support pick it up after a restart",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:     "domain",
+						Usage:    "Domain (common name) of the certificate",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "id",
+						Usage:    "Id of the archived certificate, see the history command",
+						Required: true,
+					},
+					historyRetentionFlag(),
+				}, storageFlags()...),
+				Action: func(c *cli.Context) error {
+					key, err := certstore.CertificateKey(c.String("domain"))
+					if err != nil {
+						return err
+					}
+					storage, err := newStorage(c)
+					if err != nil {
+						return err
+					}
+					defer storage.Close()
+
+					_, err = certstore.Restore(storage, key, c.String("id"), c.Int("history.retention"))
+					return err
+				},
+			},
+			gcCommand(),
+			{
+				Name:  "migrate",
+				Usage: "copy the account and all certificates to another storage, records already copied are skipped",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "from",
+						Usage:    "Source storage as backend:target, e.g. local:/var/lib/certjunkie",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "to",
+						Usage:    "Target storage as backend:target, e.g. s3:bucket/prefix?region=eu-central-1",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Only show which records would be copied",
+					},
+					&cli.StringFlag{
+						Name:    "storage.encryption.key",
+						Usage:   "Encryption keys of the storages, required to verify encrypted records",
+						EnvVars: flagSetHelperEnvKey("STORAGE_ENCRYPTION_KEY"),
+					},
+					&cli.StringFlag{
+						Name:    "storage.encryption.key-file",
+						Usage:   "Read the encryption keys from file",
+						EnvVars: flagSetHelperEnvKey("STORAGE_ENCRYPTION_KEY_FILE"),
+					},
+				},
+				Action: func(c *cli.Context) error {
+					keyring, err := newKeyring(c)
+					if err != nil {
+						return err
+					}
+					fromSpec, err := parseStorageSpec(c.String("from"))
+					if err != nil {
+						return err
+					}
+					toSpec, err := parseStorageSpec(c.String("to"))
+					if err != nil {
+						return err
+					}
+
+					from, err := openStorage(fromSpec, keyring)
+					if err != nil {
+						return fmt.Errorf("cannot open source storage: %v", err)
+					}
+					defer from.Close()
+					to, err := openStorage(toSpec, keyring)
+					if err != nil {
+						return fmt.Errorf("cannot open target storage: %v", err)
+					}
+					defer to.Close()
+
+					result, err := certstore.Migrate(from, to, c.Bool("dry-run"))
+					if result != nil {
+						log.Info().Int("copied", result.Copied).Int("skipped", result.Skipped).Int("invalid", len(result.Invalid)).Bool("dry_run", c.Bool("dry-run")).Msg("storage migrated")
+					}
+					if err != nil {
+						return fmt.Errorf("migration failed, run it again to resume: %v", err)
+					}
+					if len(result.Invalid) > 0 {
+						return fmt.Errorf("invalid records have not been migrated: %s", strings.Join(result.Invalid, ", "))
+					}
+					return nil
+				},
+			},
+		},
+	}
+}